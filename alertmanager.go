@@ -0,0 +1,202 @@
+package nowpaste
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// AlertmanagerWebhook is the payload of Prometheus Alertmanager webhook (version 4).
+// see also https://prometheus.io/docs/alerting/latest/configuration/#webhook_config
+type AlertmanagerWebhook struct {
+	Version           string              `json:"version"`
+	GroupKey          string              `json:"groupKey"`
+	TruncatedAlerts   int                 `json:"truncatedAlerts"`
+	Status            string              `json:"status"`
+	Receiver          string              `json:"receiver"`
+	GroupLabels       map[string]string   `json:"groupLabels"`
+	CommonLabels      map[string]string   `json:"commonLabels"`
+	CommonAnnotations map[string]string   `json:"commonAnnotations"`
+	ExternalURL       string              `json:"externalURL"`
+	Alerts            []AlertmanagerAlert `json:"alerts"`
+}
+
+type AlertmanagerAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+const (
+	alertColorFiring   = "#E01E5A"
+	alertColorResolved = "#2EB67D"
+)
+
+// maxAlertsPerStatus keeps messages under the limit of 50 blocks.
+const maxAlertsPerStatus = 5
+
+func (nwp *NowPaste) postAlertmanager(w http.ResponseWriter, req *http.Request) {
+	var webhook AlertmanagerWebhook
+	payload, ok := readWebhookPayload(w, req, &webhook)
+	if !ok {
+		return
+	}
+	nwp.postWebhookContent(w, req, webhook.Content(), "alertmanager", payload)
+}
+
+// Content renders the webhook as a Slack message, which groups alerts by status.
+func (webhook *AlertmanagerWebhook) Content() *Content {
+	grouped := make(map[string][]AlertmanagerAlert)
+	for _, alert := range webhook.Alerts {
+		grouped[alert.Status] = append(grouped[alert.Status], alert)
+	}
+	content := &Content{
+		Text: webhook.title(),
+	}
+	for _, status := range []string{"firing", "resolved"} {
+		alerts := grouped[status]
+		if len(alerts) == 0 {
+			continue
+		}
+		content.Attachments = append(content.Attachments, webhook.attachment(status, alerts))
+	}
+	return content
+}
+
+func (webhook *AlertmanagerWebhook) title() string {
+	var firing int
+	for _, alert := range webhook.Alerts {
+		if alert.Status == "firing" {
+			firing++
+		}
+	}
+	title := "[" + strings.ToUpper(webhook.Status)
+	if webhook.Status == "firing" {
+		title += fmt.Sprintf(":%d", firing)
+	}
+	title += "]"
+	if name := webhook.GroupLabels["alertname"]; name != "" {
+		title += " " + name
+	}
+	if labels := formatLabels(webhook.GroupLabels, "alertname"); labels != "" {
+		title += " (" + labels + ")"
+	}
+	return title
+}
+
+func (webhook *AlertmanagerWebhook) attachment(status string, alerts []AlertmanagerAlert) slack.Attachment {
+	color := alertColorFiring
+	if status == "resolved" {
+		color = alertColorResolved
+	}
+	header := fmt.Sprintf("*%s* (%d)", strings.ToUpper(status), len(alerts))
+	if webhook.ExternalURL != "" {
+		header += fmt.Sprintf(" <%s|Alertmanager>", webhook.ExternalURL)
+	}
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, header, false, false), nil, nil),
+	}
+	for i, alert := range alerts {
+		if i >= maxAlertsPerStatus {
+			blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(
+				slack.MarkdownType, fmt.Sprintf("and %d more alerts", len(alerts)-i), false, false,
+			)))
+			break
+		}
+		blocks = append(blocks, slack.NewDividerBlock())
+		blocks = append(blocks, alert.blocks()...)
+	}
+	return slack.Attachment{
+		Color:    color,
+		Fallback: fmt.Sprintf("[%s] %d alerts", strings.ToUpper(status), len(alerts)),
+		Blocks:   slack.Blocks{BlockSet: blocks},
+	}
+}
+
+func (alert *AlertmanagerAlert) blocks() []slack.Block {
+	name := alert.Labels["alertname"]
+	if name == "" {
+		name = alert.Fingerprint
+	}
+	title := "*" + name + "*"
+	if alert.GeneratorURL != "" {
+		title = fmt.Sprintf("*<%s|%s>*", alert.GeneratorURL, name)
+	}
+	for _, key := range []string{"summary", "description"} {
+		if v := alert.Annotations[key]; v != "" {
+			title += "\n" + v
+		}
+	}
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, title, false, false), nil, nil),
+	}
+	fields := make([]*slack.TextBlockObject, 0)
+	for _, key := range sortedKeys(alert.Labels) {
+		if key == "alertname" {
+			continue
+		}
+		fields = append(fields, slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("*%s*\n%s", key, alert.Labels[key]), false, false))
+	}
+	for _, key := range sortedKeys(alert.Annotations) {
+		if key == "summary" || key == "description" {
+			continue
+		}
+		fields = append(fields, slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("*%s*\n%s", key, alert.Annotations[key]), false, false))
+	}
+	// a section block can have up to 10 fields.
+	for len(fields) > 0 {
+		n := min(len(fields), 10)
+		blocks = append(blocks, slack.NewSectionBlock(nil, fields[:n], nil))
+		fields = fields[n:]
+	}
+	elements := []slack.MixedElement{}
+	if !alert.StartsAt.IsZero() {
+		elements = append(elements, slack.NewTextBlockObject(slack.MarkdownType, "Started: "+slackDate(alert.StartsAt), false, false))
+	}
+	if alert.Status == "resolved" && !alert.EndsAt.IsZero() {
+		elements = append(elements, slack.NewTextBlockObject(slack.MarkdownType, "Ended: "+slackDate(alert.EndsAt), false, false))
+	}
+	if len(elements) > 0 {
+		blocks = append(blocks, slack.NewContextBlock("", elements...))
+	}
+	return blocks
+}
+
+// slackDate formats t with Slack date formatting, which is shown in the reader's timezone.
+func slackDate(t time.Time) string {
+	return fmt.Sprintf("<!date^%d^{date_short_pretty} {time_secs}|%s>", t.Unix(), t.UTC().Format(time.RFC3339))
+}
+
+func formatLabels(labels map[string]string, excludes ...string) string {
+	parts := make([]string, 0, len(labels))
+	for _, key := range sortedKeys(labels) {
+		excluded := false
+		for _, e := range excludes {
+			if key == e {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			parts = append(parts, key+"="+labels[key])
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
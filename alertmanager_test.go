@@ -0,0 +1,60 @@
+package nowpaste
+
+import (
+	_ "embed"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sebdah/goldie/v2"
+)
+
+//go:embed testdata/alertmanager_webhook.json
+var alertmanagerWebhookPayload string
+
+func TestPostAlertmanager(t *testing.T) {
+	g := goldie.New(t,
+		goldie.WithFixtureDir("testdata/post_alertmanager/"),
+	)
+	cases := []postRootTestCase{
+		{
+			name: "firing_and_resolved",
+			path: "/alertmanager/C0123456789",
+			requestHeaders: map[string]string{
+				"Content-Type": "application/json",
+			},
+			newRequestBody: func(t *testing.T) io.Reader {
+				return strings.NewReader(alertmanagerWebhookPayload)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "as_file",
+			path: "/alertmanager/C0123456789?as_file=true&summary=alerts",
+			requestHeaders: map[string]string{
+				"Content-Type": "application/json",
+			},
+			newRequestBody: func(t *testing.T) io.Reader {
+				return strings.NewReader(alertmanagerWebhookPayload)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "invalid_payload",
+			path: "/alertmanager/C0123456789",
+			requestHeaders: map[string]string{
+				"Content-Type": "application/json",
+			},
+			newRequestBody: func(t *testing.T) io.Reader {
+				return strings.NewReader("not json")
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.Run(t, g)
+		})
+	}
+}
@@ -2,15 +2,21 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/mashiike/nowpaste"
 
+	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
@@ -38,6 +44,18 @@ func main() {
 		Writer:   os.Stderr,
 	}
 	log.SetOutput(filter)
+	if len(os.Args) > 1 && os.Args[1] == "routes" {
+		if err := runRoutes(os.Args[2:], os.Stdin, os.Stdout); err != nil {
+			log.Fatalln("[error]", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := runReplay(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalln("[error]", err)
+		}
+		return
+	}
 	var (
 		minLevel           string
 		pathPrefix         string
@@ -47,9 +65,40 @@ func main() {
 		basicPass          string
 		searchChannelTypes string
 		jsonAutoFile       bool
+		snsVerifySignature bool
+		snsPolicies        string
+		snsLifecycleNotice bool
+		snsSubscriptions   string
+		snsFailurePolicy   string
+		deadLetterDir      string
+		defaultChannel     string
+		githubSecret       string
+		githubVerify       bool
+		threadStoreFile    string
+		threadKeyTTL       time.Duration
+		messageStoreFile   string
+		messageKeyTTL      time.Duration
+		idempotencyFile    string
+		idempotencyTTL     time.Duration
+		scheduleDir        string
+		schedulerInterval  time.Duration
+		routesFile         string
+		templatesDir       string
+		async              bool
+		queueDir           string
+		queueWorkers       int
+		queueMaxAttempts   int
+		queueDrainTimeout  time.Duration
+		retryMaxAttempts   int
+		retryBudget        time.Duration
+		retryRateLimitWait time.Duration
+		slackRateLimit     bool
+		orderedDelivery    bool
 	)
 	flag.CommandLine.Usage = func() {
 		fmt.Fprintln(flag.CommandLine.Output(), "nowpaste [options]")
+		fmt.Fprintln(flag.CommandLine.Output(), "nowpaste routes [options] [sample.json]")
+		fmt.Fprintln(flag.CommandLine.Output(), "nowpaste replay [options] [id ...]")
 		fmt.Fprintln(flag.CommandLine.Output(), "version:", Version)
 		flag.CommandLine.PrintDefaults()
 	}
@@ -61,7 +110,36 @@ func main() {
 	flag.StringVar(&basicPass, "basic-pass", "", "basic auth pass")
 	flag.StringVar(&searchChannelTypes, "search-channel-types", "", "search channel types. comma separated enums (public_channel,private_channel,mpim,im)")
 	flag.BoolVar(&jsonAutoFile, "json-auto-file", false, "auto file upload for json content")
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	flag.BoolVar(&snsVerifySignature, "sns-verify-signature", true, "verify signatures of Amazon SNS messages, which rejects raw message delivery")
+	flag.StringVar(&snsPolicies, "sns-subscription-policies", "", "path to JSON file of Amazon SNS subscription policies")
+	flag.BoolVar(&snsLifecycleNotice, "sns-lifecycle-notice", true, "post notices when Amazon SNS subscriptions are confirmed or unsubscribed")
+	flag.StringVar(&snsSubscriptions, "sns-subscription-store-file", "", "path to JSON file to persist Amazon SNS subscriptions known by nowpaste (default in memory)")
+	flag.StringVar(&snsFailurePolicy, "sns-failure-policy", nowpaste.SNSFailurePolicyIgnore, "how to answer Amazon SNS when posting fails (ignore,retry,dead-letter)")
+	flag.StringVar(&deadLetterDir, "dead-letter-dir", "", "directory to store contents which failed to post")
+	flag.StringVar(&defaultChannel, "default-channel", "", "default channel for AWS Lambda events (SNS, SQS, EventBridge) and Amazon SNS subscription confirmations without channels")
+	flag.StringVar(&githubSecret, "github-webhook-secret", "", "secret to verify GitHub webhook signatures")
+	flag.BoolVar(&githubVerify, "github-verify-signature", true, "verify signatures of GitHub webhooks, which requires -github-webhook-secret")
+	flag.StringVar(&threadStoreFile, "thread-store-file", "", "path to JSON file to persist thread keys (default in memory)")
+	flag.DurationVar(&threadKeyTTL, "thread-key-ttl", nowpaste.DefaultThreadKeyTTL, "duration to keep the parent message of a thread key")
+	flag.StringVar(&messageStoreFile, "message-store-file", "", "path to JSON file to persist message keys (default in memory)")
+	flag.DurationVar(&messageKeyTTL, "message-key-ttl", nowpaste.DefaultMessageKeyTTL, "duration to keep the messages of a message key")
+	flag.StringVar(&idempotencyFile, "idempotency-store-file", "", "path to JSON file to persist idempotency keys (default in memory)")
+	flag.DurationVar(&idempotencyTTL, "idempotency-ttl", nowpaste.DefaultIdempotencyTTL, "duration to remember the result of an idempotency key")
+	flag.StringVar(&scheduleDir, "schedule-dir", "", "directory to persist scheduled jobs which Slack can not schedule, which enables the scheduler of nowpaste")
+	flag.DurationVar(&schedulerInterval, "scheduler-interval", nowpaste.DefaultSchedulerInterval, "interval to post the scheduled jobs which are due")
+	flag.StringVar(&routesFile, "routes-file", "", "path to YAML or JSON file of routing rules for requests without channels")
+	flag.StringVar(&templatesDir, "templates-dir", "", "directory of templates (*.tmpl) to render JSON payloads")
+	flag.BoolVar(&async, "async", false, "enqueue contents and answer 202 Accepted, then deliver them in the background")
+	flag.StringVar(&queueDir, "queue-dir", "", "directory to persist queued jobs of the async mode (required for -async)")
+	flag.IntVar(&queueWorkers, "queue-workers", nowpaste.DefaultQueueWorkers, "number of workers to deliver queued jobs")
+	flag.IntVar(&queueMaxAttempts, "queue-max-attempts", nowpaste.DefaultQueueMaxAttempts, "max number of attempts to deliver a queued job or a job of the scheduler")
+	flag.DurationVar(&queueDrainTimeout, "queue-drain-timeout", nowpaste.DefaultQueueDrainTimeout, "duration to deliver queued jobs on shutdown")
+	flag.IntVar(&retryMaxAttempts, "retry-max-attempts", nowpaste.DefaultRetryMaxAttempts, "max number of attempts of a Slack API call failed transiently")
+	flag.DurationVar(&retryBudget, "retry-budget", nowpaste.DefaultRetryBudget, "total duration to retry a Slack API call failed transiently")
+	flag.DurationVar(&retryRateLimitWait, "retry-max-rate-limit-wait", nowpaste.DefaultRetryMaxRateLimitWait, "max total duration to wait for Retry-After of a rate limited Slack API call, out of -retry-budget")
+	flag.BoolVar(&slackRateLimit, "slack-rate-limit", true, "space Slack API calls by the rate limit tiers of the methods, and pause the calls of a rate limited method")
+	flag.BoolVar(&orderedDelivery, "ordered-delivery", false, "post the contents to the same channel in the order of arrival")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 	if ssmPath := os.Getenv("NOWPASTE_SSM_PATH"); ssmPath != "" {
 		flag.VisitAll(SSMParameterPathToFlag(ctx, ssmPath, "NOWPASTE_"))
@@ -86,7 +164,156 @@ func main() {
 	if jsonAutoFile {
 		app.SetJSONAutoFile(true)
 	}
+	app.SetRateLimit(slackRateLimit)
+	app.SetOrderedDelivery(orderedDelivery)
+	app.SetRetryMaxAttempts(retryMaxAttempts)
+	app.SetRetryBudget(retryBudget)
+	app.SetRetryMaxRateLimitWait(retryRateLimitWait)
+	app.SetSNSSignatureVerification(snsVerifySignature)
+	app.SetSNSLifecycleNotice(snsLifecycleNotice)
+	if snsSubscriptions != "" {
+		store, err := nowpaste.NewFileSNSSubscriptionStore(snsSubscriptions)
+		if err != nil {
+			log.Fatalln("[error]", err)
+		}
+		app.SetSNSSubscriptionStore(store)
+	}
+	if deadLetterDir != "" {
+		store, err := nowpaste.NewFileDeadLetterStore(deadLetterDir)
+		if err != nil {
+			log.Fatalln("[error]", err)
+		}
+		app.SetDeadLetterStore(store)
+	}
+	if err := app.SetSNSFailurePolicy(snsFailurePolicy); err != nil {
+		log.Fatalln("[error]", err)
+	}
+	if snsPolicies != "" {
+		bs, err := os.ReadFile(snsPolicies)
+		if err != nil {
+			log.Fatalln("[error] read sns subscription policies:", err)
+		}
+		policies, err := nowpaste.ParseSNSSubscriptionPolicies(bs)
+		if err != nil {
+			log.Fatalln("[error]", err)
+		}
+		if err := app.SetSNSSubscriptionPolicies(policies); err != nil {
+			log.Fatalln("[error]", err)
+		}
+	}
+	if templatesDir != "" {
+		templates, err := nowpaste.LoadTemplates(templatesDir)
+		if err != nil {
+			log.Fatalln("[error]", err)
+		}
+		app.SetTemplates(templates)
+	}
+	if routesFile != "" {
+		bs, err := os.ReadFile(routesFile)
+		if err != nil {
+			log.Fatalln("[error] read routing config:", err)
+		}
+		config, err := nowpaste.ParseRoutingConfig(bs)
+		if err != nil {
+			log.Fatalln("[error]", err)
+		}
+		if err := app.SetRoutingConfig(config); err != nil {
+			log.Fatalln("[error]", err)
+		}
+	}
+	app.SetDefaultChannel(defaultChannel)
+	app.SetGitHubWebhookSecret(githubSecret)
+	app.SetGitHubSignatureVerification(githubVerify)
+	if threadStoreFile != "" {
+		store, err := nowpaste.NewFileThreadStore(threadStoreFile)
+		if err != nil {
+			log.Fatalln("[error]", err)
+		}
+		app.SetThreadStore(store)
+	}
+	app.SetThreadKeyTTL(threadKeyTTL)
+	if messageStoreFile != "" {
+		store, err := nowpaste.NewFileMessageStore(messageStoreFile)
+		if err != nil {
+			log.Fatalln("[error]", err)
+		}
+		app.SetMessageStore(store)
+	}
+	app.SetMessageKeyTTL(messageKeyTTL)
+	if idempotencyFile != "" {
+		store, err := nowpaste.NewFileIdempotencyStore(idempotencyFile)
+		if err != nil {
+			log.Fatalln("[error]", err)
+		}
+		app.SetIdempotencyStore(store)
+	}
+	app.SetIdempotencyTTL(idempotencyTTL)
+	if scheduleDir != "" {
+		store, err := nowpaste.NewFileScheduleStore(scheduleDir)
+		if err != nil {
+			log.Fatalln("[error]", err)
+		}
+		app.SetScheduleStore(store)
+	}
+	// the max attempts are shared by the queue and the scheduler.
+	app.SetQueueMaxAttempts(queueMaxAttempts)
+	if async && isLambda() {
+		// the workers do not run after the response on AWS Lambda, so contents are posted in the requests.
+		log.Println("[warn] async mode is not supported on AWS Lambda")
+		async = false
+	}
+	if async {
+		if queueDir == "" {
+			log.Fatalln("[error] -queue-dir is required for the async mode, not to lose the accepted jobs")
+		}
+		store, err := nowpaste.NewFileQueueStore(queueDir)
+		if err != nil {
+			log.Fatalln("[error]", err)
+		}
+		app.SetQueueStore(store)
+		app.SetQueueDrainTimeout(queueDrainTimeout)
+	}
+	if isLambda() {
+		// the scheduler does not run on AWS Lambda, so contents which Slack can not schedule are rejected.
+		app.SetScheduleStore(nil)
+		r := ridge.New(listen, pathPrefix, app)
+		handler := app.LambdaHandler(func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+			req, err := r.RequestBuilder(event)
+			if err != nil {
+				log.Println("[error]", err)
+				return nil, err
+			}
+			w := ridge.NewResponseWriter()
+			r.Mux.ServeHTTP(w, req.WithContext(ctx))
+			return w.Response(), nil
+		})
+		lambda.StartWithOptions(handler, lambda.WithContext(ctx))
+		return
+	}
+	if scheduleDir != "" {
+		go func() {
+			if err := app.RunScheduler(ctx, schedulerInterval); err != nil && !errors.Is(err, context.Canceled) {
+				log.Println("[error] scheduler:", err)
+			}
+		}()
+	}
+	var wg sync.WaitGroup
+	if async {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := app.RunQueueWorkers(ctx, queueWorkers); err != nil && !errors.Is(err, context.Canceled) {
+				log.Println("[error] queue workers:", err)
+			}
+		}()
+	}
 	ridge.RunWithContext(ctx, listen, pathPrefix, app)
+	// wait for the workers to drain the queue.
+	wg.Wait()
+}
+
+func isLambda() bool {
+	return strings.HasPrefix(os.Getenv("AWS_EXECUTION_ENV"), "AWS_Lambda") || os.Getenv("AWS_LAMBDA_RUNTIME_API") != ""
 }
 
 func SSMParameterPathToFlag(ctx context.Context, ssmPath string, prefix string) func(*flag.Flag) {
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mashiike/nowpaste"
+)
+
+// runReplay posts the dead letters in the dead letter dir again, and deletes the dead letters which are posted.
+func runReplay(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	var (
+		deadLetterDir string
+		token         string
+		source        string
+		before        string
+		dryRun        bool
+	)
+	fs.StringVar(&deadLetterDir, "dead-letter-dir", os.Getenv("NOWPASTE_DEAD_LETTER_DIR"), "directory of dead letters")
+	fs.StringVar(&token, "slack-token", os.Getenv("NOWPASTE_SLACK_TOKEN"), "slack token")
+	fs.StringVar(&source, "source", "", "replay only the dead letters of the source (e.g. amazon-sns, default, scheduler)")
+	fs.StringVar(&before, "before", "", "replay only the dead letters created before the time (Unix time or RFC3339)")
+	fs.BoolVar(&dryRun, "dry-run", false, "list the dead letters to replay without posting")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "nowpaste replay [options] [id ...]")
+		fmt.Fprintln(fs.Output(), "posts the dead letters of the ids again, or all the dead letters which match the options if no id is given.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if deadLetterDir == "" {
+		return fmt.Errorf("dead-letter-dir is required")
+	}
+	if token == "" && !dryRun {
+		return fmt.Errorf("slack-token is required")
+	}
+	store, err := nowpaste.NewFileDeadLetterStore(deadLetterDir)
+	if err != nil {
+		return err
+	}
+	app := nowpaste.New(token)
+	app.SetDeadLetterStore(store)
+	filter := &nowpaste.DeadLetterFilter{Source: source}
+	if before != "" {
+		t, err := nowpaste.ParseScheduleTime(before)
+		if err != nil {
+			return fmt.Errorf("before must be a Unix time or RFC3339: %w", err)
+		}
+		filter.Before = t.Time
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	dls, err := app.ListDeadLetters(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		ids := make(map[string]bool, fs.NArg())
+		for _, id := range fs.Args() {
+			ids[id] = true
+		}
+		selected := dls[:0]
+		for _, dl := range dls {
+			if ids[dl.ID] {
+				selected = append(selected, dl)
+				delete(ids, dl.ID)
+			}
+		}
+		if len(ids) > 0 {
+			missing := make([]string, 0, len(ids))
+			for id := range ids {
+				missing = append(missing, id)
+			}
+			sort.Strings(missing)
+			return fmt.Errorf("%w: %s", nowpaste.ErrDeadLetterNotFound, strings.Join(missing, ", "))
+		}
+		dls = selected
+	}
+	var failed int
+	for _, dl := range dls {
+		if dryRun {
+			fmt.Fprintf(stdout, "%s\t%s\t%s\tattempts=%d\t%s\n", dl.ID, dl.Source, dl.CreatedAt.Format(time.RFC3339), dl.Attempts, dl.Error)
+			continue
+		}
+		result, err := app.RedriveDeadLetter(ctx, dl.ID)
+		if err != nil {
+			failed++
+			fmt.Fprintf(stdout, "%s\tfailed\t%s\n", dl.ID, err.Error())
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		fmt.Fprintf(stdout, "%s\treplayed\t%s\n", dl.ID, result.ChannelID)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d dead letters failed to replay", failed, len(dls))
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mashiike/nowpaste"
+)
+
+// runRoutes evaluates a sample request against the routing config, and prints the matched rules and the routed content.
+func runRoutes(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("routes", flag.ContinueOnError)
+	var routesFile string
+	fs.StringVar(&routesFile, "routes-file", os.Getenv("NOWPASTE_ROUTES_FILE"), "path to YAML or JSON file of routing rules")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "nowpaste routes [options] [sample.json]")
+		fmt.Fprintln(fs.Output(), "evaluates a sample request (path, topic_arn, subject, message_attributes, headers, payload) against the routing rules. reads stdin if no sample is given.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if routesFile == "" {
+		return fmt.Errorf("routes-file is required")
+	}
+	bs, err := os.ReadFile(routesFile)
+	if err != nil {
+		return fmt.Errorf("read routing config: %w", err)
+	}
+	config, err := nowpaste.ParseRoutingConfig(bs)
+	if err != nil {
+		return err
+	}
+	var sample []byte
+	if fs.NArg() > 0 {
+		sample, err = os.ReadFile(fs.Arg(0))
+	} else {
+		sample, err = io.ReadAll(stdin)
+	}
+	if err != nil {
+		return fmt.Errorf("read sample: %w", err)
+	}
+	var req nowpaste.RoutingRequest
+	if err := json.Unmarshal(sample, &req); err != nil {
+		return fmt.Errorf("decode sample: %w", err)
+	}
+	rules := config.Route(&req)
+	content := &nowpaste.Content{}
+	nowpaste.ApplyRoutingRules(content, rules)
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Matched []*nowpaste.RoutingRule `json:"matched"`
+		Content *nowpaste.Content       `json:"content"`
+	}{
+		Matched: rules,
+		Content: content,
+	})
+}
@@ -0,0 +1,527 @@
+package nowpaste
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DeadLetter is a content which nowpaste failed to post.
+type DeadLetter struct {
+	ID        string           `json:"id"`
+	Source    string           `json:"source"`
+	Content   *Content         `json:"content,omitempty"`
+	Error     string           `json:"error"`
+	Permanent bool             `json:"permanent"`
+	Attempts  int              `json:"attempts"`
+	Request   *RequestMetadata `json:"request,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// RequestMetadata is the metadata of the original request of a content, to trace dead letters.
+type RequestMetadata struct {
+	Method     string `json:"method,omitempty"`
+	Path       string `json:"path,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	TopicArn   string `json:"topic_arn,omitempty"`
+	MessageID  string `json:"message_id,omitempty"`
+	JobID      string `json:"job_id,omitempty"`
+}
+
+func newRequestMetadata(req *http.Request) *RequestMetadata {
+	return &RequestMetadata{
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		RemoteAddr: req.RemoteAddr,
+		UserAgent:  req.UserAgent(),
+	}
+}
+
+func newSNSRequestMetadata(req *http.Request, n *HTTPNotification) *RequestMetadata {
+	meta := newRequestMetadata(req)
+	meta.TopicArn = n.TopicArn
+	meta.MessageID = n.MessageId
+	return meta
+}
+
+type DeadLetterStore interface {
+	Put(ctx context.Context, dl *DeadLetter) error
+	Get(ctx context.Context, id string) (dl *DeadLetter, ok bool, err error)
+	Delete(ctx context.Context, id string) error
+	// List returns the dead letters in the order of creation.
+	List(ctx context.Context) ([]*DeadLetter, error)
+}
+
+func sortDeadLetters(dls []*DeadLetter) {
+	sort.SliceStable(dls, func(i, j int) bool {
+		return dls[i].CreatedAt.Before(dls[j].CreatedAt)
+	})
+}
+
+type InmemoryDeadLetterStore struct {
+	mu      sync.RWMutex
+	letters map[string]*DeadLetter
+}
+
+var _ DeadLetterStore = (*InmemoryDeadLetterStore)(nil)
+
+func NewInmemoryDeadLetterStore() *InmemoryDeadLetterStore {
+	return &InmemoryDeadLetterStore{
+		letters: make(map[string]*DeadLetter),
+	}
+}
+
+func (s *InmemoryDeadLetterStore) Put(_ context.Context, dl *DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.letters[dl.ID] = dl
+	return nil
+}
+
+func (s *InmemoryDeadLetterStore) Get(_ context.Context, id string) (*DeadLetter, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dl, ok := s.letters[id]
+	return dl, ok, nil
+}
+
+func (s *InmemoryDeadLetterStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.letters, id)
+	return nil
+}
+
+func (s *InmemoryDeadLetterStore) List(_ context.Context) ([]*DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dls := make([]*DeadLetter, 0, len(s.letters))
+	for _, dl := range s.letters {
+		dls = append(dls, dl)
+	}
+	sortDeadLetters(dls)
+	return dls, nil
+}
+
+// FileDeadLetterStore appends dead letters to daily JSONL files in the directory.
+// Updating or deleting a dead letter rewrites the file which has it.
+type FileDeadLetterStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+var _ DeadLetterStore = (*FileDeadLetterStore)(nil)
+
+func NewFileDeadLetterStore(dir string) (*FileDeadLetterStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create dead letter dir: %w", err)
+	}
+	return &FileDeadLetterStore{dir: dir}, nil
+}
+
+func (s *FileDeadLetterStore) Put(_ context.Context, dl *DeadLetter) error {
+	bs, err := json.Marshal(dl)
+	if err != nil {
+		return fmt.Errorf("marshal dead letter: %w", err)
+	}
+	bs = append(bs, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := filepath.Join(s.dir, dl.CreatedAt.UTC().Format("2006-01-02")+".jsonl")
+	if found, err := s.rewriteFile(name, dl.ID, bs); err != nil || found {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open dead letter file: %w", err)
+	}
+	if _, err := f.Write(bs); err != nil {
+		f.Close()
+		return fmt.Errorf("write dead letter: %w", err)
+	}
+	return f.Close()
+}
+
+func (s *FileDeadLetterStore) Get(_ context.Context, id string) (*DeadLetter, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dls, err := s.readAll()
+	if err != nil {
+		return nil, false, err
+	}
+	for _, dl := range dls {
+		if dl.ID == id {
+			return dl, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (s *FileDeadLetterStore) List(_ context.Context) ([]*DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dls, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+	sortDeadLetters(dls)
+	return dls, nil
+}
+
+func (s *FileDeadLetterStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names, err := s.files()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if found, err := s.rewriteFile(name, id, nil); err != nil || found {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteFile replaces the line of the dead letter of the id in the file with the line, or removes it if the line is nil.
+// It returns false if the file does not have the dead letter. Must be called with the lock held.
+func (s *FileDeadLetterStore) rewriteFile(name string, id string, replacement []byte) (bool, error) {
+	bs, err := os.ReadFile(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("read %s: %w", name, err)
+	}
+	var kept bytes.Buffer
+	var found bool
+	for _, line := range bytes.SplitAfter(bs, []byte("\n")) {
+		var dl DeadLetter
+		if err := json.Unmarshal(line, &dl); err == nil && dl.ID == id {
+			found = true
+			kept.Write(replacement)
+			continue
+		}
+		kept.Write(line)
+	}
+	if !found {
+		return false, nil
+	}
+	if kept.Len() == 0 {
+		if err := os.Remove(name); err != nil {
+			return true, fmt.Errorf("remove %s: %w", name, err)
+		}
+		return true, nil
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, kept.Bytes(), 0o644); err != nil {
+		return true, fmt.Errorf("write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return true, fmt.Errorf("rename %s: %w", tmp, err)
+	}
+	return true, nil
+}
+
+func (s *FileDeadLetterStore) files() ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(s.dir, "*.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", s.dir, err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// readAll reads the dead letters of all the files, and skips broken lines with warnings. Must be called with the lock held.
+func (s *FileDeadLetterStore) readAll() ([]*DeadLetter, error) {
+	names, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	dls := make([]*DeadLetter, 0)
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("open %s: %w", name, err)
+		}
+		r := bufio.NewReader(f)
+		for lineNo := 1; ; lineNo++ {
+			line, err := r.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				var dl DeadLetter
+				if err := json.Unmarshal(line, &dl); err != nil {
+					log.Printf("[warn] decode %s:%d: %s", name, lineNo, err.Error())
+				} else {
+					dls = append(dls, &dl)
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					f.Close()
+					return nil, fmt.Errorf("read %s: %w", name, err)
+				}
+				break
+			}
+		}
+		f.Close()
+	}
+	return dls, nil
+}
+
+func newID() string {
+	var b [16]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// putDeadLetter stores the dead letter of the source, content, attempts and request, failed with the error.
+func (nwp *NowPaste) putDeadLetter(ctx context.Context, dl *DeadLetter, err error) error {
+	if nwp.deadLetters == nil {
+		return errors.New("dead letter store is not configured")
+	}
+	dl.ID = newID()
+	dl.Error = err.Error()
+	dl.Permanent = IsPermanentError(err)
+	dl.CreatedAt = time.Now()
+	if dl.Attempts == 0 {
+		dl.Attempts = 1
+	}
+	if err := nwp.deadLetters.Put(ctx, dl); err != nil {
+		return fmt.Errorf("put dead letter: %w", err)
+	}
+	log.Printf("[info] dead letter `%s` stored from %s", dl.ID, dl.Source)
+	return nil
+}
+
+// DeadLetterFilter selects dead letters. Empty conditions match anything.
+type DeadLetterFilter struct {
+	Source string
+	Before time.Time
+}
+
+func (f *DeadLetterFilter) Match(dl *DeadLetter) bool {
+	if f.Source != "" && dl.Source != f.Source {
+		return false
+	}
+	if !f.Before.IsZero() && !dl.CreatedAt.Before(f.Before) {
+		return false
+	}
+	return true
+}
+
+// ListDeadLetters returns the dead letters which match the filter.
+func (nwp *NowPaste) ListDeadLetters(ctx context.Context, filter *DeadLetterFilter) ([]*DeadLetter, error) {
+	if nwp.deadLetters == nil {
+		return nil, errors.New("dead letter store is not configured")
+	}
+	dls, err := nwp.deadLetters.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list dead letters: %w", err)
+	}
+	matched := make([]*DeadLetter, 0, len(dls))
+	for _, dl := range dls {
+		if filter == nil || filter.Match(dl) {
+			matched = append(matched, dl)
+		}
+	}
+	return matched, nil
+}
+
+// RedriveDeadLetter posts the content of the dead letter again, and deletes the dead letter if succeeded.
+// If failed, the dead letter is kept with the error and the attempts counted up.
+// Redrives of the same dead letter are serialized, so that the content is posted once.
+func (nwp *NowPaste) RedriveDeadLetter(ctx context.Context, id string) (*PostResult, error) {
+	if nwp.deadLetters == nil {
+		return nil, errors.New("dead letter store is not configured")
+	}
+	unlock := nwp.deadLetterLocks.Lock(id)
+	defer unlock()
+	dl, ok, err := nwp.deadLetters.Get(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("get dead letter `%s`: %w", id, err)
+	}
+	if !ok || dl.Content == nil {
+		return nil, fmt.Errorf("%w: %s", ErrDeadLetterNotFound, id)
+	}
+	content := *dl.Content
+	result, postErr := nwp.postContent(ctx, &content)
+	if postErr != nil {
+		dl.Attempts++
+		dl.Error = postErr.Error()
+		dl.Permanent = IsPermanentError(postErr)
+		if err := nwp.deadLetters.Put(ctx, dl); err != nil {
+			return nil, fmt.Errorf("redrive dead letter `%s`: %w, and update the dead letter: %w", id, postErr, err)
+		}
+		return nil, fmt.Errorf("redrive dead letter `%s`: %w", id, postErr)
+	}
+	log.Printf("[info] dead letter `%s` redriven to %s", id, result.ChannelID)
+	if err := nwp.deadLetters.Delete(ctx, id); err != nil {
+		return result, fmt.Errorf("dead letter `%s` is redriven, but delete it: %w", id, err)
+	}
+	return result, nil
+}
+
+// PurgeDeadLetters deletes the dead letters which match the filter, and returns the number of the deleted.
+func (nwp *NowPaste) PurgeDeadLetters(ctx context.Context, filter *DeadLetterFilter) (int, error) {
+	dls, err := nwp.ListDeadLetters(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	for i, dl := range dls {
+		if err := nwp.deadLetters.Delete(ctx, dl.ID); err != nil {
+			return i, fmt.Errorf("delete dead letter `%s`: %w", dl.ID, err)
+		}
+	}
+	log.Printf("[info] %d dead letters purged", len(dls))
+	return len(dls), nil
+}
+
+func newDeadLetterFilter(req *http.Request) (*DeadLetterFilter, error) {
+	filter := &DeadLetterFilter{Source: req.FormValue("source")}
+	if before := req.FormValue("before"); before != "" {
+		t, err := ParseScheduleTime(before)
+		if err != nil {
+			return nil, fmt.Errorf("before must be a Unix time or RFC3339: %w", err)
+		}
+		filter.Before = t.Time
+	}
+	return filter, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[warn] write response failed: %s", err.Error())
+	}
+}
+
+func (nwp *NowPaste) getDeadLetters(w http.ResponseWriter, req *http.Request) {
+	if nwp.deadLetters == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	filter, err := newDeadLetterFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dls, err := nwp.ListDeadLetters(req.Context(), filter)
+	if err != nil {
+		log.Printf("[error] %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	// contents can be large, so the list has the summaries only. get each dead letter to inspect the content.
+	summaries := make([]DeadLetter, 0, len(dls))
+	for _, dl := range dls {
+		summary := *dl
+		summary.Content = nil
+		summaries = append(summaries, summary)
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (nwp *NowPaste) getDeadLetter(w http.ResponseWriter, req *http.Request) {
+	if nwp.deadLetters == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	dl, ok, err := nwp.deadLetters.Get(req.Context(), mux.Vars(req)["id"])
+	if err != nil {
+		log.Printf("[error] get dead letter: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, dl)
+}
+
+func (nwp *NowPaste) postDeadLetterRedrive(w http.ResponseWriter, req *http.Request) {
+	if nwp.deadLetters == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	result, err := nwp.RedriveDeadLetter(req.Context(), mux.Vars(req)["id"])
+	if err != nil {
+		if errors.Is(err, ErrDeadLetterNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		writePostError(w, err)
+		return
+	}
+	nwp.writePostResult(w, req, result)
+}
+
+func (nwp *NowPaste) postDeadLettersPurge(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if nwp.deadLetters == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	var purged int
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ids := req.Form["id"]; len(ids) > 0 {
+		for _, id := range ids {
+			for _, id := range strings.Split(id, ",") {
+				id = strings.TrimSpace(id)
+				_, ok, err := nwp.deadLetters.Get(req.Context(), id)
+				if err == nil && ok {
+					err = nwp.deadLetters.Delete(req.Context(), id)
+				}
+				if err != nil {
+					log.Printf("[error] delete dead letter `%s`: %s", id, err.Error())
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				if ok {
+					purged++
+				}
+			}
+		}
+		log.Printf("[info] %d dead letters purged", purged)
+	} else {
+		filter, err := newDeadLetterFilter(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// an empty filter matches all the dead letters, so purging all must be explicit.
+		if filter.Source == "" && filter.Before.IsZero() && req.FormValue("all") != "true" {
+			http.Error(w, "id, source, before or all=true is required", http.StatusBadRequest)
+			return
+		}
+		if purged, err = nwp.PurgeDeadLetters(req.Context(), filter); err != nil {
+			log.Printf("[error] %s", err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"purged": purged})
+}
@@ -0,0 +1,192 @@
+package nowpaste
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileDeadLetterStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileDeadLetterStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := t.Context()
+	day := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, id := range []string{"dl1", "dl2", "dl3"} {
+		dl := &DeadLetter{
+			ID:        id,
+			Source:    "amazon-sns",
+			Content:   &Content{Channel: "C0123456789", Text: id},
+			Error:     "channel_not_found",
+			CreatedAt: day.Add(time.Duration(i) * 12 * time.Hour),
+		}
+		if err := store.Put(ctx, dl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "2030-01-01.jsonl"), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(f, "broken\n")
+	f.Close()
+
+	updated := &DeadLetter{ID: "dl1", Source: "amazon-sns", Attempts: 2, CreatedAt: day}
+	if err := store.Put(ctx, updated); err != nil {
+		t.Fatal(err)
+	}
+
+	dls, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 3 || dls[0].Attempts != 2 || dls[0].ID != "dl1" || dls[2].ID != "dl3" {
+		t.Fatalf("unexpected dead letters: %#v", dls)
+	}
+	if dl, ok, err := store.Get(ctx, "dl2"); err != nil || !ok || dl.Content.Text != "dl2" {
+		t.Errorf("unexpected dead letter: %#v, %v", dl, err)
+	}
+	for _, id := range []string{"dl2", "dl3", "unknown"} {
+		if err := store.Delete(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok, _ := store.Get(ctx, "dl2"); ok {
+		t.Error("expected dl2 to be deleted")
+	}
+	if dls, _ := store.List(ctx); len(dls) != 1 || dls[0].ID != "dl1" {
+		t.Errorf("unexpected dead letters: %#v", dls)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2030-01-02.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("expected the empty file to be removed: %v", err)
+	}
+}
+
+func TestDeadLetterAdmin(t *testing.T) {
+	var mu sync.Mutex
+	posted := make(map[string]int)
+	nwp := newMockedNowPaste(t, io.Discard, mockChannelNotFound("#missing", &mu, posted))
+	store := NewInmemoryDeadLetterStore()
+	nwp.SetDeadLetterStore(store)
+	serve := func(method string, target string, body io.Reader) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, body)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		nwp.ServeHTTP(w, req)
+		return w
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/amazon-sns/%23missing", strings.NewReader(`{"Type":"Notification","MessageId":"m1","TopicArn":"arn:aws:sns:us-east-1:123456789012:alarms","Message":"hello"}`))
+	nwp.SetSNSSignatureVerification(false)
+	if err := nwp.SetSNSFailurePolicy(SNSFailurePolicyDeadLetter); err != nil {
+		t.Fatal(err)
+	}
+	nwp.ServeHTTP(httptest.NewRecorder(), req)
+	for _, channel := range []string{"C0123456789", "#missing"} {
+		if err := nwp.putDeadLetter(t.Context(), &DeadLetter{Source: "lambda-sqs", Content: &Content{Channel: channel, Text: "hello"}}, ErrChannelNotFound); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := serve(http.MethodGet, "/admin/dead-letters?source=amazon-sns", nil)
+	var dls []DeadLetter
+	if err := json.NewDecoder(w.Body).Decode(&dls); err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 1 || dls[0].Content != nil || dls[0].Attempts != 1 || dls[0].Request == nil || dls[0].Request.MessageID != "m1" {
+		t.Fatalf("unexpected dead letters: %#v", dls)
+	}
+	w = serve(http.MethodGet, "/admin/dead-letters/"+dls[0].ID, nil)
+	var dl DeadLetter
+	if err := json.NewDecoder(w.Body).Decode(&dl); err != nil {
+		t.Fatal(err)
+	}
+	if dl.Content == nil || dl.Content.Text != "hello" || dl.Request.TopicArn != "arn:aws:sns:us-east-1:123456789012:alarms" {
+		t.Errorf("unexpected dead letter: %#v", dl)
+	}
+
+	all, _ := nwp.ListDeadLetters(t.Context(), &DeadLetterFilter{Source: "lambda-sqs"})
+	if len(all) != 2 {
+		t.Fatalf("unexpected dead letters: %d", len(all))
+	}
+	w = serve(http.MethodPost, "/admin/dead-letters/"+all[0].ID+"/redrive", nil)
+	if w.Code != http.StatusOK || posted["C0123456789"] != 1 {
+		t.Errorf("expected to redrive, got status %d", w.Code)
+	}
+	if _, ok, _ := store.Get(t.Context(), all[0].ID); ok {
+		t.Error("expected the redriven dead letter to be deleted")
+	}
+	w = serve(http.MethodPost, "/admin/dead-letters/"+all[1].ID+"/redrive", nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected to fail to redrive, got status %d", w.Code)
+	}
+	if got, ok, _ := store.Get(t.Context(), all[1].ID); !ok || got.Attempts != 2 {
+		t.Errorf("expected the dead letter to be kept with attempts, got %#v", got)
+	}
+	if w := serve(http.MethodPost, "/admin/dead-letters/unknown/redrive", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+
+	if w := serve(http.MethodPost, "/admin/dead-letters/purge", strings.NewReader("")); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for the purge without filters, got %d", w.Code)
+	}
+	w = serve(http.MethodPost, "/admin/dead-letters/purge", strings.NewReader(url.Values{"source": {"lambda-sqs"}}.Encode()))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"purged":1}` {
+		t.Errorf("unexpected purge: %d %s", w.Code, w.Body.String())
+	}
+	w = serve(http.MethodPost, "/admin/dead-letters/purge", strings.NewReader(url.Values{"id": {dls[0].ID + ",unknown"}}.Encode()))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"purged":1}` {
+		t.Errorf("unexpected purge: %d %s", w.Code, w.Body.String())
+	}
+	if dls, _ := store.List(t.Context()); len(dls) != 0 {
+		t.Errorf("expected all purged, got %d", len(dls))
+	}
+}
+
+func TestRedriveDeadLetterConcurrently(t *testing.T) {
+	var mu sync.Mutex
+	posted := make(map[string]int)
+	nwp := newMockedNowPaste(t, io.Discard, mockChannelNotFound("#missing", &mu, posted))
+	store, err := NewFileDeadLetterStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nwp.SetDeadLetterStore(store)
+	dl := &DeadLetter{Source: "default", Content: &Content{Channel: "C0123456789", Text: "hello"}}
+	if err := nwp.putDeadLetter(t.Context(), dl, ErrChannelNotFound); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	var redriven int64
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := nwp.RedriveDeadLetter(t.Context(), dl.ID); err == nil {
+				atomic.AddInt64(&redriven, 1)
+			} else if !errors.Is(err, ErrDeadLetterNotFound) {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if redriven != 1 || posted["C0123456789"] != 1 {
+		t.Errorf("expected to redrive once, redriven %d, posted %d", redriven, posted["C0123456789"])
+	}
+}
@@ -0,0 +1,121 @@
+package nowpaste
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+const (
+	directMessagePrefixHandle = "@"
+	directMessagePrefixUser   = "user:"
+	directMessagePrefixEmail  = "email:"
+	// directMessageSeparator joins the users of a group direct message, e.g. `@alice+@bob`.
+	directMessageSeparator = "+"
+)
+
+// isDirectMessage reports whether the channel addresses users instead of a channel, e.g. `@alice`, `user:U0123456789` or `email:alice@example.com`.
+func isDirectMessage(channel string) bool {
+	return strings.HasPrefix(channel, directMessagePrefixHandle) ||
+		strings.HasPrefix(channel, directMessagePrefixUser) ||
+		strings.HasPrefix(channel, directMessagePrefixEmail)
+}
+
+// openDirectMessage opens the direct message (or the group direct message for multiple users) of the users, and returns the channel ID.
+// The channel ID is cached by the target, so users are resolved only once.
+func (nwp *NowPaste) openDirectMessage(ctx context.Context, target string) (string, error) {
+	if channelID, ok, err := nwp.cache.Get(ctx, target); err == nil && ok {
+		return channelID, nil
+	}
+	targets := strings.Split(target, directMessageSeparator)
+	userIDs := make([]string, 0, len(targets))
+	for _, t := range targets {
+		userID, err := nwp.lookupUser(ctx, strings.TrimSpace(t))
+		if err != nil {
+			return "", err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	var channel *slack.Channel
+	err, _ := nwp.retrier.Do(ctx, func() error {
+		var err error
+		channel, _, _, err = nwp.client.OpenConversationContext(ctx, &slack.OpenConversationParameters{
+			Users:    userIDs,
+			ReturnIM: true,
+		})
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("open direct message with %s: %w", strings.Join(userIDs, ","), err)
+	}
+	log.Printf("[info] open direct message %s for %s", channel.ID, target)
+	if err := nwp.cache.SetMulti(ctx, []ChannelCacheEntry{
+		{ChannelName: target, ChannelID: channel.ID, TTL: time.Now().Add(24 * time.Hour)},
+	}); err != nil {
+		log.Printf("[warn] cache set failed: %s", err.Error())
+	}
+	return channel.ID, nil
+}
+
+// lookupUser returns the user ID of `@handle`, `user:<user_id>` or `email:<address>`.
+func (nwp *NowPaste) lookupUser(ctx context.Context, target string) (string, error) {
+	switch {
+	case strings.HasPrefix(target, directMessagePrefixUser):
+		return strings.TrimPrefix(target, directMessagePrefixUser), nil
+	case strings.HasPrefix(target, directMessagePrefixEmail):
+		email := strings.TrimPrefix(target, directMessagePrefixEmail)
+		var user *slack.User
+		err, _ := nwp.retrier.Do(ctx, func() error {
+			var err error
+			user, err = nwp.client.GetUserByEmailContext(ctx, email)
+			return err
+		})
+		if err != nil {
+			return "", fmt.Errorf("lookup user by email %s: %w", email, err)
+		}
+		return user.ID, nil
+	case strings.HasPrefix(target, directMessagePrefixHandle):
+		return nwp.searchUser(ctx, strings.TrimPrefix(target, directMessagePrefixHandle))
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUserNotFound, target)
+	}
+}
+
+// searchUser searches the user whose name or display name is the handle.
+func (nwp *NowPaste) searchUser(ctx context.Context, handle string) (string, error) {
+	log.Println("[info] try search user to ", handle)
+	p := nwp.client.GetUsersPaginated()
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		default:
+		}
+		var next slack.UserPagination
+		err, _ := nwp.retrier.Do(ctx, func() error {
+			var err error
+			next, err = p.Next(ctx)
+			return err
+		})
+		if p.Done(err) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("search user: %w", err)
+		}
+		p = next
+		for _, u := range p.Users {
+			if u.Deleted {
+				continue
+			}
+			if u.Name == handle || u.Profile.DisplayName == handle {
+				return u.ID, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: @%s", ErrUserNotFound, handle)
+}
@@ -0,0 +1,114 @@
+package nowpaste
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type mockedDirectMessage struct {
+	usersList  int64
+	openUsers  string
+	postedTo   string
+	lookupMail string
+}
+
+func (m *mockedDirectMessage) middleware(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/users.list":
+			atomic.AddInt64(&m.usersList, 1)
+			io.WriteString(w, `{"ok":true,"members":[
+				{"id":"U0000000001","name":"alice.old","deleted":true,"profile":{"display_name":"alice"}},
+				{"id":"U0000000002","name":"alice","profile":{"display_name":"Alice"}},
+				{"id":"U0000000003","name":"robert","profile":{"display_name":"bob"}}
+			],"response_metadata":{"next_cursor":""}}`)
+		case "/api/users.lookupByEmail":
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			m.lookupMail = r.PostForm.Get("email")
+			if m.lookupMail != "carol@example.com" {
+				io.WriteString(w, `{"ok":false,"error":"users_not_found"}`)
+				return
+			}
+			io.WriteString(w, `{"ok":true,"user":{"id":"U0000000004","name":"carol"}}`)
+		case "/api/conversations.open":
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			m.openUsers = r.PostForm.Get("users")
+			io.WriteString(w, `{"ok":true,"channel":{"id":"D0123456789"}}`)
+		case "/api/chat.postMessage":
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			m.postedTo = r.PostForm.Get("channel")
+			next(w, r)
+		default:
+			next(w, r)
+		}
+	}
+}
+
+func TestPostDirectMessage(t *testing.T) {
+	cases := []struct {
+		name          string
+		query         string
+		expectedUsers string
+	}{
+		{name: "handle", query: "channel=@alice", expectedUsers: "U0000000002"},
+		{name: "display_name", query: "channel=@bob", expectedUsers: "U0000000003"},
+		{name: "user_id", query: "channel=user:U0000000005", expectedUsers: "U0000000005"},
+		{name: "email", query: "email=carol@example.com", expectedUsers: "U0000000004"},
+		{name: "group", query: "channel=@alice%2Buser:U0000000005%2Bemail:carol@example.com", expectedUsers: "U0000000002,U0000000005,U0000000004"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &mockedDirectMessage{}
+			nwp := newMockedNowPaste(t, io.Discard, m.middleware)
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodPost, "/?"+c.query, strings.NewReader("hello"))
+				req.Header.Set("Content-Type", "text/plain")
+				w := httptest.NewRecorder()
+				nwp.ServeHTTP(w, req)
+				if w.Code != http.StatusOK {
+					t.Fatalf("unexpected status: %d", w.Code)
+				}
+				if i == 0 && m.openUsers != c.expectedUsers {
+					t.Errorf("expected to open direct message with %s, got %s", c.expectedUsers, m.openUsers)
+				}
+				if m.postedTo != "D0123456789" {
+					t.Errorf("expected to post to the direct message, got %s", m.postedTo)
+				}
+				m.openUsers = ""
+			}
+			if m.usersList > 1 {
+				t.Errorf("expected the direct message to be cached, users.list called %d times", m.usersList)
+			}
+		})
+	}
+}
+
+func TestPostDirectMessageUserNotFound(t *testing.T) {
+	for _, query := range []string{"channel=@nobody", "email=nobody@example.com"} {
+		m := &mockedDirectMessage{}
+		nwp := newMockedNowPaste(t, io.Discard, m.middleware)
+		req := httptest.NewRequest(http.MethodPost, "/?"+query, strings.NewReader("hello"))
+		req.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+		nwp.ServeHTTP(w, req)
+		if w.Code == http.StatusOK {
+			t.Errorf("%s: expected to fail", query)
+		}
+		if m.postedTo != "" {
+			t.Errorf("%s: expected not to post, posted to %s", query, m.postedTo)
+		}
+	}
+}
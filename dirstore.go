@@ -0,0 +1,117 @@
+package nowpaste
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// fileDirStore persists values as JSON files in the directory, one file per id.
+// Each file is written to a temporary file and renamed, so a value is never partially written.
+type fileDirStore[V any] struct {
+	mu   sync.Mutex
+	dir  string
+	kind string
+}
+
+func newFileDirStore[V any](dir string, kind string) (*fileDirStore[V], error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create %s dir: %w", kind, err)
+	}
+	return &fileDirStore[V]{dir: dir, kind: kind}, nil
+}
+
+func (s *fileDirStore[V]) path(id string) (string, error) {
+	if id == "" || filepath.Base(id) != id || strings.HasPrefix(id, ".") {
+		return "", fmt.Errorf("invalid %s id `%s`", s.kind, id)
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+func (s *fileDirStore[V]) put(id string, v V) error {
+	name, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", s.kind, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, bs, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return fmt.Errorf("rename %s: %w", tmp, err)
+	}
+	return nil
+}
+
+// get returns the value of the id. An invalid id is not found.
+func (s *fileDirStore[V]) get(id string) (V, bool, error) {
+	name, err := s.path(id)
+	if err != nil {
+		var zero V
+		return zero, false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(name)
+}
+
+func (s *fileDirStore[V]) read(name string) (V, bool, error) {
+	var v V
+	bs, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return v, false, nil
+		}
+		return v, false, fmt.Errorf("read %s: %w", name, err)
+	}
+	if err := json.Unmarshal(bs, &v); err != nil {
+		return v, false, fmt.Errorf("decode %s: %w", name, err)
+	}
+	return v, true, nil
+}
+
+func (s *fileDirStore[V]) delete(id string) error {
+	name, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove %s: %w", name, err)
+	}
+	return nil
+}
+
+// list returns all the values, and skips broken files with warnings.
+func (s *fileDirStore[V]) list() ([]V, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", s.dir, err)
+	}
+	values := make([]V, 0, len(names))
+	for _, name := range names {
+		v, ok, err := s.read(name)
+		if err != nil {
+			log.Printf("[warn] %s", err.Error())
+			continue
+		}
+		if ok {
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
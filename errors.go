@@ -0,0 +1,114 @@
+package nowpaste
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/slack-go/slack"
+)
+
+// ErrChannelNotFound is returned when the channel of the content can not be resolved.
+var ErrChannelNotFound = errors.New("channel not found")
+
+// ErrChannelRequired is returned when the content has no channel.
+var ErrChannelRequired = errors.New("channel is required")
+
+// ErrMessageNotFound is returned when no message is recorded for the message key.
+var ErrMessageNotFound = errors.New("message not found")
+
+// ErrMessageRequired is returned when neither ts nor message key is specified to update or delete a message.
+var ErrMessageRequired = errors.New("ts or message_key is required")
+
+// ErrUserNotFound is returned when the user of a direct message can not be resolved.
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrScheduleNotSupported is returned when the content can not be scheduled by Slack and no schedule store is configured.
+var ErrScheduleNotSupported = errors.New("schedule is not supported for the content without schedule store")
+
+// ErrDeadLetterNotFound is returned when no dead letter is stored for the id.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// transientSlackErrors are Slack API errors that may succeed by retrying later.
+var transientSlackErrors = map[string]bool{
+	"internal_error":      true,
+	"fatal_error":         true,
+	"service_unavailable": true,
+	"request_timeout":     true,
+	"ratelimited":         true,
+}
+
+// isTransientError reports whether err is a failure which may succeed by retrying soon:
+// rate limits, server errors and transient errors of Slack, and network errors.
+// Unlike !IsPermanentError, unknown errors are not transient, so that they are not retried blindly.
+func isTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var rle *slack.RateLimitedError
+	if errors.As(err, &rle) {
+		return rle.Retryable()
+	}
+	var sce slack.StatusCodeError
+	if errors.As(err, &sce) {
+		return sce.Retryable()
+	}
+	var ser slack.SlackErrorResponse
+	if errors.As(err, &ser) {
+		return transientSlackErrors[ser.Err]
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// IsPermanentError reports whether err is a failure which does not succeed by retrying, e.g. `channel_not_found`.
+// Network errors, server errors and rate limits of Slack are not permanent.
+func IsPermanentError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrChannelNotFound) || errors.Is(err, ErrChannelRequired) ||
+		errors.Is(err, ErrMessageNotFound) || errors.Is(err, ErrMessageRequired) ||
+		errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrScheduleNotSupported) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return false
+	}
+	var foe *FanOutError
+	if errors.As(err, &foe) {
+		return foe.isPermanent()
+	}
+	var rle *slack.RateLimitedError
+	if errors.As(err, &rle) {
+		return false
+	}
+	var sce slack.StatusCodeError
+	if errors.As(err, &sce) {
+		return !sce.Retryable()
+	}
+	var ser slack.SlackErrorResponse
+	if errors.As(err, &ser) {
+		if transientSlackErrors[ser.Err] {
+			return false
+		}
+		// other errors (e.g. channel_not_found, invalid_blocks) are the result of a processed request, so treat as permanent.
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return false
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package nowpaste_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mashiike/nowpaste"
+	"github.com/slack-go/slack"
+)
+
+func TestIsPermanentError(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{err: nil, expected: false},
+		{err: fmt.Errorf("upload files: %w", nowpaste.ErrChannelNotFound), expected: true},
+		{err: nowpaste.ErrChannelRequired, expected: true},
+		{err: nowpaste.ErrScheduleNotSupported, expected: true},
+		{err: fmt.Errorf("lookup user: %w", nowpaste.ErrUserNotFound), expected: true},
+		{err: fmt.Errorf("post message: %w", slack.SlackErrorResponse{Err: "channel_not_found"}), expected: true},
+		{err: fmt.Errorf("post message: %w", slack.SlackErrorResponse{Err: "invalid_blocks"}), expected: true},
+		{err: fmt.Errorf("post message: %w", slack.SlackErrorResponse{Err: "internal_error"}), expected: false},
+		{err: slack.StatusCodeError{Code: 503, Status: "503 Service Unavailable"}, expected: false},
+		{err: slack.StatusCodeError{Code: 404, Status: "404 Not Found"}, expected: true},
+		{err: &slack.RateLimitedError{}, expected: false},
+		{err: context.DeadlineExceeded, expected: false},
+		{err: errors.New("connection reset by peer"), expected: false},
+	}
+	for _, c := range cases {
+		if got := nowpaste.IsPermanentError(c.err); got != c.expected {
+			t.Errorf("IsPermanentError(%v): got %v, expected %v", c.err, got, c.expected)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package nowpaste
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DefaultFanOutConcurrency is the default number of channels posted concurrently for a content with multiple channels.
+const DefaultFanOutConcurrency = 4
+
+// channelSeparator separates the channels of a content posted to multiple channels, e.g. `#ops,#service`.
+const channelSeparator = ","
+
+// ChannelPostResult is the result of posting to one of the channels of a content.
+type ChannelPostResult struct {
+	Channel string      `json:"channel"`
+	Result  *PostResult `json:"result,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// FanOutError is returned when posting to all the channels of a content fails.
+type FanOutError struct {
+	Results []ChannelPostResult
+	errs    []error
+}
+
+func (e *FanOutError) Error() string {
+	msgs := make([]string, 0, len(e.Results))
+	for _, r := range e.Results {
+		msgs = append(msgs, r.Channel+": "+r.Error)
+	}
+	return "post to all channels failed: " + strings.Join(msgs, ", ")
+}
+
+func (e *FanOutError) Unwrap() []error {
+	return e.errs
+}
+
+// isPermanent reports whether all the failures are permanent.
+func (e *FanOutError) isPermanent() bool {
+	for _, err := range e.errs {
+		if !IsPermanentError(err) {
+			return false
+		}
+	}
+	return len(e.errs) > 0
+}
+
+// splitChannels splits the channel of a content into unique channels.
+func splitChannels(channel string) []string {
+	channels := make([]string, 0, 1)
+	seen := make(map[string]bool)
+	for _, c := range strings.Split(channel, channelSeparator) {
+		c = strings.TrimSpace(c)
+		if c == "" || seen[c] {
+			continue
+		}
+		seen[c] = true
+		channels = append(channels, c)
+	}
+	return channels
+}
+
+// unmarshalChannel decodes the channel of a content, which is a string or an array of strings.
+// An array is joined with the channel separator.
+func unmarshalChannel(bs json.RawMessage) (string, error) {
+	if len(bs) == 0 || string(bs) == "null" {
+		return "", nil
+	}
+	var channel string
+	if err := json.Unmarshal(bs, &channel); err == nil {
+		return channel, nil
+	}
+	var channels []string
+	if err := json.Unmarshal(bs, &channels); err != nil {
+		return "", fmt.Errorf("channel must be a string or an array of strings: %w", err)
+	}
+	return strings.Join(channels, channelSeparator), nil
+}
+
+// postFanOut posts copies of the content to each channel concurrently.
+// It succeeds if posting to any of the channels succeeds, and the result reports the result of each channel.
+func (nwp *NowPaste) postFanOut(ctx context.Context, content *Content, channels []string) (*PostResult, error) {
+	concurrency := nwp.fanOutConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	results := make([]ChannelPostResult, len(channels))
+	errs := make([]error, len(channels))
+	sem := make(chan struct{}, concurrency)
+	tickets := nwp.takeTurns(ctx, channels)
+	var wg sync.WaitGroup
+	for i, channel := range channels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer tickets[i].release()
+			results[i].Channel = channel
+			err := tickets[i].wait(ctx)
+			var result *PostResult
+			if err == nil {
+				sem <- struct{}{}
+				c := *content
+				c.Channel = channel
+				if content.IdempotencyKey != "" {
+					c.IdempotencyKey = content.IdempotencyKey + "\x00" + channel
+				}
+				result, err = nwp.postContentToChannel(ctx, &c)
+				<-sem
+			}
+			if err != nil {
+				log.Printf("[error] post to %s: %s", channel, err.Error())
+				results[i].Error = err.Error()
+				errs[i] = err
+				return
+			}
+			results[i].Result = result
+		}()
+	}
+	wg.Wait()
+	var fanOut *PostResult
+	failures := make([]error, 0, len(errs))
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+		if fanOut == nil {
+			r := *results[i].Result
+			fanOut = &r
+		}
+	}
+	if fanOut == nil {
+		return nil, &FanOutError{Results: results, errs: failures}
+	}
+	if len(failures) > 0 {
+		log.Printf("[warn] post to %d of %d channels failed", len(failures), len(channels))
+	}
+	fanOut.Results = results
+	return fanOut, nil
+}
+
+// writeChannelResults answers the result of each channel as a line, for the clients which do not accept JSON.
+// It answers 207 Multi-Status if posting to some of the channels failed.
+func writeChannelResults(w http.ResponseWriter, results []ChannelPostResult) {
+	status := http.StatusOK
+	var body strings.Builder
+	for _, r := range results {
+		if r.Error != "" {
+			status = http.StatusMultiStatus
+			fmt.Fprintf(&body, "%s\terror\t%s\n", r.Channel, r.Error)
+			continue
+		}
+		fmt.Fprintf(&body, "%s\tok\n", r.Channel)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, body.String())
+}
@@ -0,0 +1,149 @@
+package nowpaste
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mockChannelNotFound answers channel_not_found for posting to the channel, and records the channels posted to.
+func mockChannelNotFound(missing string, mu *sync.Mutex, posted map[string]int) middleware {
+	return func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/chat.postMessage" {
+				next(w, r)
+				return
+			}
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			channel := r.PostForm.Get("channel")
+			if channel == missing {
+				io.WriteString(w, `{"ok":false,"error":"channel_not_found"}`)
+				return
+			}
+			mu.Lock()
+			posted[channel]++
+			mu.Unlock()
+			next(w, r)
+		}
+	}
+}
+
+func TestContentUnmarshalChannels(t *testing.T) {
+	cases := map[string]string{
+		`{"channel":"C0123456789"}`:                  "C0123456789",
+		`{"channel":["C0123456789","#ops"]}`:         "C0123456789,#ops",
+		`{"channel":"C0123456789,#ops","text":"hi"}`: "C0123456789,#ops",
+		`{"text":"hi"}`:                              "",
+	}
+	for body, expected := range cases {
+		var content Content
+		if err := json.Unmarshal([]byte(body), &content); err != nil {
+			t.Fatal(err)
+		}
+		if content.Channel != expected {
+			t.Errorf("%s: expected channel %q, got %q", body, expected, content.Channel)
+		}
+	}
+	var content Content
+	if err := json.Unmarshal([]byte(`{"channel":1}`), &content); err == nil {
+		t.Error("expected error for invalid channel")
+	}
+	if got := splitChannels(" #ops, C0123456789,,#ops "); strings.Join(got, "|") != "#ops|C0123456789" {
+		t.Errorf("unexpected channels: %v", got)
+	}
+}
+
+func TestPostFanOut(t *testing.T) {
+	var mu sync.Mutex
+	posted := make(map[string]int)
+	nwp := newMockedNowPaste(t, io.Discard, mockChannelNotFound("#missing", &mu, posted))
+	nwp.SetFanOutConcurrency(2)
+	body := `{"channel":["C0000000001","#missing","C0000000002","C0000000003"],"text":"deploy finished"}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Idempotency-Key", "deploy-42")
+	w := httptest.NewRecorder()
+	nwp.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	var result PostResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Results) != 4 {
+		t.Fatalf("expected results of 4 channels, got %#v", result.Results)
+	}
+	for i, channel := range []string{"C0000000001", "#missing", "C0000000002", "C0000000003"} {
+		r := result.Results[i]
+		if r.Channel != channel {
+			t.Errorf("results[%d]: expected channel %s, got %s", i, channel, r.Channel)
+		}
+		if channel == "#missing" {
+			if r.Error == "" || r.Result != nil {
+				t.Errorf("expected failure for %s, got %#v", channel, r)
+			}
+			continue
+		}
+		if r.Error != "" || r.Result == nil {
+			t.Errorf("expected success for %s, got %#v", channel, r)
+		}
+		if posted[channel] != 1 {
+			t.Errorf("expected to post to %s once, posted %d times", channel, posted[channel])
+		}
+	}
+}
+
+func TestPostFanOutPlainText(t *testing.T) {
+	var mu sync.Mutex
+	posted := make(map[string]int)
+	nwp := newMockedNowPaste(t, io.Discard, mockChannelNotFound("#missing", &mu, posted))
+	post := func(channels string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/?channel="+url.QueryEscape(channels), strings.NewReader("deploy finished"))
+		req.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+		nwp.ServeHTTP(w, req)
+		return w
+	}
+	w := post("C0000000001,#missing")
+	if w.Code != http.StatusMultiStatus {
+		t.Errorf("expected 207 for the partial failure, got %d", w.Code)
+	}
+	if expected := "C0000000001\tok\n#missing\terror\tpost message: channel_not_found\n"; w.Body.String() != expected {
+		t.Errorf("unexpected body: %q", w.Body.String())
+	}
+	if w := post("C0000000001,C0000000002"); w.Code != http.StatusOK || w.Body.String() != "C0000000001\tok\nC0000000002\tok\n" {
+		t.Errorf("unexpected response: %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestPostFanOutAllFailed(t *testing.T) {
+	var mu sync.Mutex
+	posted := make(map[string]int)
+	nwp := newMockedNowPaste(t, io.Discard, mockChannelNotFound("#missing", &mu, posted))
+	_, err := nwp.postContent(t.Context(), &Content{Channel: "#missing,#missing, #missing", Text: "hello"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "channel_not_found") {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	_, err = nwp.postContent(t.Context(), &Content{Channel: "#missing,#other", Text: "hello"})
+	if err != nil {
+		t.Fatalf("expected partial success, got %s", err.Error())
+	}
+	_, err = nwp.postFanOut(t.Context(), &Content{Text: "hello"}, []string{"#missing", "#missing"})
+	if err == nil || !IsPermanentError(err) {
+		t.Errorf("expected permanent error, got %v", err)
+	}
+}
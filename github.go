@@ -0,0 +1,300 @@
+package nowpaste
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// GitHubWebhook is the subset of GitHub webhook payloads used for formatting.
+// see also https://docs.github.com/en/webhooks/webhook-events-and-payloads
+type GitHubWebhook struct {
+	Action     string `json:"action"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		HTMLURL  string `json:"html_url"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+
+	// push
+	Ref     string `json:"ref"`
+	Compare string `json:"compare"`
+	Deleted bool   `json:"deleted"`
+	Forced  bool   `json:"forced"`
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
+
+	PullRequest *struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+		Merged  bool   `json:"merged"`
+		Base    struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+		Head struct {
+			Ref string `json:"ref"`
+		} `json:"head"`
+	} `json:"pull_request"`
+	Issue *struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+	} `json:"issue"`
+	Release *struct {
+		Name       string `json:"name"`
+		TagName    string `json:"tag_name"`
+		HTMLURL    string `json:"html_url"`
+		Prerelease bool   `json:"prerelease"`
+	} `json:"release"`
+	WorkflowRun *struct {
+		Name       string `json:"name"`
+		RunNumber  int    `json:"run_number"`
+		HTMLURL    string `json:"html_url"`
+		Status     string `json:"status"`
+		Conclusion string `json:"conclusion"`
+		HeadBranch string `json:"head_branch"`
+	} `json:"workflow_run"`
+	CheckSuite *struct {
+		Status     string `json:"status"`
+		Conclusion string `json:"conclusion"`
+		HeadBranch string `json:"head_branch"`
+		HeadSHA    string `json:"head_sha"`
+	} `json:"check_suite"`
+}
+
+const maxGitHubPushCommits = 5
+
+// VerifyGitHubSignature verifies the X-Hub-Signature-256 header value of the payload.
+func VerifyGitHubSignature(secret string, payload []byte, signature string) bool {
+	sig, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	expected, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// githubEventAllowed reports whether the event is allowed by the `events` query param.
+// Each entry is an event name (e.g. `pull_request`) or an event name with an action (e.g. `pull_request.opened`).
+func githubEventAllowed(filter string, event string, action string) bool {
+	if filter == "" {
+		return true
+	}
+	for _, entry := range strings.Split(filter, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == event || (action != "" && entry == event+"."+action) {
+			return true
+		}
+	}
+	return false
+}
+
+func (nwp *NowPaste) postGitHub(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	payload, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.Printf("[info] can not read body: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case nwp.githubWebhookSecret != "":
+		if !VerifyGitHubSignature(nwp.githubWebhookSecret, payload, req.Header.Get("X-Hub-Signature-256")) {
+			log.Printf("[warn] github webhook signature verification failed: delivery=%s", req.Header.Get("X-GitHub-Delivery"))
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	case nwp.githubVerifySignature:
+		log.Println("[error] github webhook secret is not configured, can not verify the signature")
+		http.Error(w, "github webhook secret is not configured", http.StatusServiceUnavailable)
+		return
+	default:
+		log.Println("[warn] github webhook signature verification is disabled")
+	}
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		values, err := url.ParseQuery(string(payload))
+		if err != nil {
+			log.Printf("[info] can not read as form: %s", err.Error())
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		payload = []byte(values.Get("payload"))
+	}
+	event := req.Header.Get("X-GitHub-Event")
+	var webhook GitHubWebhook
+	if err := json.Unmarshal(payload, &webhook); err != nil {
+		log.Printf("[info] can not read as json: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	log.Printf("[info] github event=%s action=%s repository=%s", event, webhook.Action, webhook.Repository.FullName)
+	if event == "ping" {
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "pong")
+		return
+	}
+	if !githubEventAllowed(req.URL.Query().Get("events"), event, webhook.Action) {
+		log.Printf("[info] github event `%s` is filtered out", event)
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, http.StatusText(http.StatusOK))
+		return
+	}
+	content, ok := webhook.Content(event)
+	if !ok {
+		if req.URL.Query().Get("unknown_events") == "ignore" {
+			log.Printf("[info] github event `%s` is ignored", event)
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, http.StatusText(http.StatusOK))
+			return
+		}
+		content = &Content{
+			AsFile:   true,
+			Filename: "github_" + event + ".json",
+			Summary:  fmt.Sprintf("[%s] %s event", webhook.Repository.FullName, event),
+		}
+	}
+	nwp.postWebhookContent(w, req, content, "github_"+event, payload)
+}
+
+// Content renders the webhook of the event as a Slack message. It returns false for unsupported events.
+func (webhook *GitHubWebhook) Content(event string) (*Content, bool) {
+	var text string
+	switch event {
+	case "push":
+		text = webhook.pushText()
+	case "pull_request":
+		if webhook.PullRequest == nil {
+			return nil, false
+		}
+		pr := webhook.PullRequest
+		action := webhook.Action
+		if action == "closed" && pr.Merged {
+			action = "merged"
+		}
+		text = fmt.Sprintf("Pull request %s by %s: <%s|#%d %s> (%s ← %s)",
+			action, webhook.Sender.Login, pr.HTMLURL, pr.Number, escapeMrkdwn(pr.Title), pr.Base.Ref, pr.Head.Ref)
+	case "issues":
+		if webhook.Issue == nil {
+			return nil, false
+		}
+		text = fmt.Sprintf("Issue %s by %s: <%s|#%d %s>",
+			webhook.Action, webhook.Sender.Login, webhook.Issue.HTMLURL, webhook.Issue.Number, escapeMrkdwn(webhook.Issue.Title))
+	case "release":
+		if webhook.Release == nil {
+			return nil, false
+		}
+		name := webhook.Release.Name
+		if name == "" {
+			name = webhook.Release.TagName
+		}
+		kind := "Release"
+		if webhook.Release.Prerelease {
+			kind = "Pre-release"
+		}
+		text = fmt.Sprintf("%s %s by %s: <%s|%s>", kind, webhook.Action, webhook.Sender.Login, webhook.Release.HTMLURL, escapeMrkdwn(name))
+	case "workflow_run":
+		if webhook.WorkflowRun == nil {
+			return nil, false
+		}
+		run := webhook.WorkflowRun
+		result := run.Status
+		if run.Conclusion != "" {
+			result = run.Conclusion
+		}
+		text = fmt.Sprintf("%s Workflow <%s|%s #%d> %s on `%s`",
+			conclusionEmoji(result), run.HTMLURL, escapeMrkdwn(run.Name), run.RunNumber, result, run.HeadBranch)
+	case "check_suite":
+		if webhook.CheckSuite == nil {
+			return nil, false
+		}
+		suite := webhook.CheckSuite
+		result := suite.Status
+		if suite.Conclusion != "" {
+			result = suite.Conclusion
+		}
+		text = fmt.Sprintf("%s Check suite %s on `%s` (%s)",
+			conclusionEmoji(result), result, suite.HeadBranch, shortSHA(suite.HeadSHA))
+	default:
+		return nil, false
+	}
+	return &Content{
+		Text:      fmt.Sprintf("[<%s|%s>] %s", webhook.Repository.HTMLURL, webhook.Repository.FullName, text),
+		AsMessage: true,
+	}, true
+}
+
+func (webhook *GitHubWebhook) pushText() string {
+	ref := strings.TrimPrefix(strings.TrimPrefix(webhook.Ref, "refs/heads/"), "refs/tags/")
+	switch {
+	case webhook.Deleted:
+		return fmt.Sprintf("`%s` was deleted by %s", ref, webhook.Sender.Login)
+	case len(webhook.Commits) == 0:
+		return fmt.Sprintf("`%s` was pushed by %s", ref, webhook.Sender.Login)
+	}
+	var b strings.Builder
+	noun := "commits"
+	if len(webhook.Commits) == 1 {
+		noun = "commit"
+	}
+	fmt.Fprintf(&b, "<%s|%d new %s> pushed to `%s` by %s", webhook.Compare, len(webhook.Commits), noun, ref, webhook.Sender.Login)
+	if webhook.Forced {
+		b.WriteString(" (forced)")
+	}
+	for i, commit := range webhook.Commits {
+		if i >= maxGitHubPushCommits {
+			fmt.Fprintf(&b, "\nand %d more commits", len(webhook.Commits)-i)
+			break
+		}
+		message, _, _ := strings.Cut(commit.Message, "\n")
+		fmt.Fprintf(&b, "\n<%s|`%s`> %s - %s", commit.URL, shortSHA(commit.ID), escapeMrkdwn(message), commit.Author.Name)
+	}
+	return b.String()
+}
+
+func conclusionEmoji(conclusion string) string {
+	switch conclusion {
+	case "success":
+		return ":white_check_mark:"
+	case "failure", "timed_out", "startup_failure":
+		return ":x:"
+	case "cancelled", "skipped", "stale", "neutral":
+		return ":white_circle:"
+	default:
+		return ":hourglass_flowing_sand:"
+	}
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+var mrkdwnEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escapeMrkdwn escapes control characters of Slack mrkdwn.
+func escapeMrkdwn(s string) string {
+	return mrkdwnEscaper.Replace(s)
+}
@@ -0,0 +1,171 @@
+package nowpaste
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sebdah/goldie/v2"
+)
+
+const testGitHubWebhookSecret = "It's a Secret to Everybody"
+
+func signGitHubPayload(payload string) string {
+	mac := hmac.New(sha256.New, []byte(testGitHubWebhookSecret))
+	mac.Write([]byte(payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+const testGitHubPushPayload = `{
+	"ref": "refs/heads/main",
+	"compare": "https://github.com/mashiike/nowpaste/compare/1111111...2222222",
+	"repository": {"full_name": "mashiike/nowpaste", "html_url": "https://github.com/mashiike/nowpaste"},
+	"sender": {"login": "octocat"},
+	"commits": [
+		{"id": "1111111aaaaaaa", "message": "fix <bug>\n\nlong description", "url": "https://github.com/mashiike/nowpaste/commit/1111111aaaaaaa", "author": {"name": "Octo Cat"}},
+		{"id": "2222222bbbbbbb", "message": "add feature", "url": "https://github.com/mashiike/nowpaste/commit/2222222bbbbbbb", "author": {"name": "Octo Cat"}}
+	]
+}`
+
+const testGitHubPullRequestPayload = `{
+	"action": "closed",
+	"repository": {"full_name": "mashiike/nowpaste", "html_url": "https://github.com/mashiike/nowpaste"},
+	"sender": {"login": "octocat"},
+	"pull_request": {
+		"number": 42, "title": "Add GitHub webhook", "html_url": "https://github.com/mashiike/nowpaste/pull/42", "merged": true,
+		"base": {"ref": "main"}, "head": {"ref": "feature/github"}
+	}
+}`
+
+func TestPostGitHub(t *testing.T) {
+	g := goldie.New(t,
+		goldie.WithFixtureDir("testdata/post_github/"),
+	)
+	cases := []struct {
+		name           string
+		event          string
+		query          string
+		payload        string
+		signature      string
+		expectedStatus int
+		expectedPosted bool
+	}{
+		{
+			name:           "push",
+			event:          "push",
+			payload:        testGitHubPushPayload,
+			expectedStatus: http.StatusOK,
+			expectedPosted: true,
+		},
+		{
+			name:           "pull_request",
+			event:          "pull_request",
+			query:          "?events=pull_request.closed",
+			payload:        testGitHubPullRequestPayload,
+			expectedStatus: http.StatusOK,
+			expectedPosted: true,
+		},
+		{
+			name:           "unknown_event",
+			event:          "star",
+			payload:        `{"action":"created","repository":{"full_name":"mashiike/nowpaste"}}`,
+			expectedStatus: http.StatusOK,
+			expectedPosted: true,
+		},
+		{
+			name:           "unknown_event_ignored",
+			event:          "star",
+			query:          "?unknown_events=ignore",
+			payload:        `{"action":"created","repository":{"full_name":"mashiike/nowpaste"}}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "filtered",
+			event:          "push",
+			query:          "?events=pull_request,release",
+			payload:        testGitHubPushPayload,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "ping",
+			event:          "ping",
+			payload:        `{"zen":"Keep it logically awesome."}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "invalid_signature",
+			event:          "push",
+			payload:        testGitHubPushPayload,
+			signature:      "sha256=0000",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var apiCallLog bytes.Buffer
+			nwp := newMockedNowPaste(t, &apiCallLog)
+			nwp.SetGitHubWebhookSecret(testGitHubWebhookSecret)
+			req := httptest.NewRequest(http.MethodPost, "/github/C0123456789"+c.query, strings.NewReader(c.payload))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-GitHub-Event", c.event)
+			signature := c.signature
+			if signature == "" {
+				signature = signGitHubPayload(c.payload)
+			}
+			req.Header.Set("X-Hub-Signature-256", signature)
+			w := httptest.NewRecorder()
+			nwp.ServeHTTP(w, req)
+			if w.Code != c.expectedStatus {
+				t.Errorf("unexpected status: got %d, expected %d", w.Code, c.expectedStatus)
+			}
+			if !c.expectedPosted {
+				if apiCallLog.Len() != 0 {
+					t.Errorf("unexpected api call: %s", apiCallLog.String())
+				}
+				return
+			}
+			g.Assert(t, c.name, apiCallLog.Bytes())
+		})
+	}
+}
+
+func TestVerifyGitHubSignature(t *testing.T) {
+	payload := "Hello, World!"
+	// example from https://docs.github.com/en/webhooks/using-webhooks/validating-webhook-deliveries
+	signature := "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	if !VerifyGitHubSignature(testGitHubWebhookSecret, []byte(payload), signature) {
+		t.Error("expected valid signature")
+	}
+	if VerifyGitHubSignature("wrong secret", []byte(payload), signature) {
+		t.Error("expected invalid signature")
+	}
+	if VerifyGitHubSignature(testGitHubWebhookSecret, []byte(payload), "sha1=757107ea") {
+		t.Error("expected invalid signature prefix")
+	}
+}
+
+func TestPostGitHubWithoutSecret(t *testing.T) {
+	var apiCallLog bytes.Buffer
+	nwp := newMockedNowPaste(t, &apiCallLog)
+	post := func() int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/github/C0123456789", strings.NewReader(`{"zen":"Keep it logically awesome."}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Event", "ping")
+		w := httptest.NewRecorder()
+		nwp.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := post(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without the secret, got %d", code)
+	}
+	nwp.SetGitHubSignatureVerification(false)
+	if code := post(); code != http.StatusOK {
+		t.Errorf("expected 200 with the verification disabled, got %d", code)
+	}
+}
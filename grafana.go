@@ -0,0 +1,200 @@
+package nowpaste
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// GrafanaWebhook is the payload of Grafana unified alerting webhook.
+// see also https://grafana.com/docs/grafana/latest/alerting/configure-notifications/manage-contact-points/integrations/webhook-notifier/
+type GrafanaWebhook struct {
+	Receiver          string            `json:"receiver"`
+	Status            string            `json:"status"`
+	OrgID             int64             `json:"orgId"`
+	Alerts            []GrafanaAlert    `json:"alerts"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Title             string            `json:"title"`
+	State             string            `json:"state"`
+	Message           string            `json:"message"`
+}
+
+type GrafanaAlert struct {
+	Status       string             `json:"status"`
+	Labels       map[string]string  `json:"labels"`
+	Annotations  map[string]string  `json:"annotations"`
+	StartsAt     time.Time          `json:"startsAt"`
+	EndsAt       time.Time          `json:"endsAt"`
+	GeneratorURL string             `json:"generatorURL"`
+	Fingerprint  string             `json:"fingerprint"`
+	SilenceURL   string             `json:"silenceURL"`
+	DashboardURL string             `json:"dashboardURL"`
+	PanelURL     string             `json:"panelURL"`
+	ImageURL     string             `json:"imageURL"`
+	Values       map[string]float64 `json:"values"`
+	ValueString  string             `json:"valueString"`
+}
+
+// maxGrafanaAlerts keeps messages under the limit of 50 blocks.
+const maxGrafanaAlerts = 10
+
+func (nwp *NowPaste) postGrafana(w http.ResponseWriter, req *http.Request) {
+	var webhook GrafanaWebhook
+	payload, ok := readWebhookPayload(w, req, &webhook)
+	if !ok {
+		return
+	}
+	content, err := webhook.Content()
+	if err != nil {
+		log.Printf("[error] render grafana webhook: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	nwp.postWebhookContent(w, req, content, "grafana", payload)
+}
+
+// Content renders the webhook as a Slack message with Block Kit blocks.
+func (webhook *GrafanaWebhook) Content() (*Content, error) {
+	title := webhook.title()
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType,
+			fmt.Sprintf("%s *%s*", grafanaStateEmoji(webhook.Status), title), false, false), nil, nil),
+	}
+	for i, alert := range webhook.Alerts {
+		if i >= maxGrafanaAlerts {
+			blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(
+				slack.MarkdownType, fmt.Sprintf("and %d more alerts", len(webhook.Alerts)-i), false, false,
+			)))
+			break
+		}
+		blocks = append(blocks, slack.NewDividerBlock())
+		blocks = append(blocks, alert.blocks(i)...)
+	}
+	if webhook.TruncatedAlerts > 0 {
+		blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(
+			slack.MarkdownType, fmt.Sprintf("%d alerts are truncated", webhook.TruncatedAlerts), false, false,
+		)))
+	}
+	bs, err := json.Marshal(slack.Blocks{BlockSet: blocks})
+	if err != nil {
+		return nil, fmt.Errorf("marshal blocks: %w", err)
+	}
+	return &Content{
+		Text:   title,
+		Blocks: bs,
+	}, nil
+}
+
+func (webhook *GrafanaWebhook) title() string {
+	if webhook.Title != "" {
+		return webhook.Title
+	}
+	title := "[" + strings.ToUpper(webhook.Status) + "]"
+	if name := webhook.GroupLabels["alertname"]; name != "" {
+		title += " " + name
+	}
+	return title
+}
+
+func (alert *GrafanaAlert) blocks(index int) []slack.Block {
+	name := alert.Labels["alertname"]
+	if name == "" {
+		name = alert.Fingerprint
+	}
+	title := fmt.Sprintf("%s *%s*", grafanaStateEmoji(alert.Status), name)
+	for _, key := range []string{"summary", "description"} {
+		if v := alert.Annotations[key]; v != "" {
+			title += "\n" + v
+		}
+	}
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, title, false, false), nil, nil),
+	}
+	elements := []slack.MixedElement{}
+	if value := alert.value(); value != "" {
+		elements = append(elements, slack.NewTextBlockObject(slack.MarkdownType, "Value: "+value, false, false))
+	}
+	if labels := formatLabels(alert.Labels, "alertname", "grafana_folder"); labels != "" {
+		elements = append(elements, slack.NewTextBlockObject(slack.MarkdownType, "Labels: "+labels, false, false))
+	}
+	if !alert.StartsAt.IsZero() {
+		elements = append(elements, slack.NewTextBlockObject(slack.MarkdownType, "Started: "+slackDate(alert.StartsAt), false, false))
+	}
+	if len(elements) > 0 {
+		blocks = append(blocks, slack.NewContextBlock("", elements...))
+	}
+	buttons := []slack.BlockElement{}
+	for _, link := range []struct {
+		label string
+		url   string
+	}{
+		{"Source", alert.GeneratorURL},
+		{"Dashboard", alert.DashboardURL},
+		{"Panel", alert.PanelURL},
+		{"Silence", alert.SilenceURL},
+	} {
+		if link.url == "" {
+			continue
+		}
+		button := slack.NewButtonBlockElement(
+			fmt.Sprintf("%s_%d", strings.ToLower(link.label), index), "",
+			slack.NewTextBlockObject(slack.PlainTextType, link.label, false, false),
+		)
+		button.URL = link.url
+		buttons = append(buttons, button)
+	}
+	if len(buttons) > 0 {
+		blocks = append(blocks, slack.NewActionBlock("", buttons...))
+	}
+	return blocks
+}
+
+// value returns the evaluated values of the alert.
+// valueString is preferred, since values are not set for alerts of Grafana 8.
+func (alert *GrafanaAlert) value() string {
+	if alert.ValueString != "" {
+		return "`" + alert.ValueString + "`"
+	}
+	if len(alert.Values) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(alert.Values))
+	for k := range alert.Values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%v", key, alert.Values[key]))
+	}
+	return "`" + strings.Join(parts, ", ") + "`"
+}
+
+func grafanaStateEmoji(status string) string {
+	switch status {
+	case "firing", "alerting":
+		return ":fire:"
+	case "resolved", "ok":
+		return ":white_check_mark:"
+	case "no_data", "nodata":
+		return ":grey_question:"
+	case "paused":
+		return ":double_vertical_bar:"
+	case "pending":
+		return ":hourglass_flowing_sand:"
+	default:
+		return ":bell:"
+	}
+}
@@ -0,0 +1,60 @@
+package nowpaste
+
+import (
+	_ "embed"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sebdah/goldie/v2"
+)
+
+//go:embed testdata/grafana_webhook.json
+var grafanaWebhookPayload string
+
+func TestPostGrafana(t *testing.T) {
+	g := goldie.New(t,
+		goldie.WithFixtureDir("testdata/post_grafana/"),
+	)
+	cases := []postRootTestCase{
+		{
+			name: "firing_and_resolved",
+			path: "/grafana/C0123456789",
+			requestHeaders: map[string]string{
+				"Content-Type": "application/json",
+			},
+			newRequestBody: func(t *testing.T) io.Reader {
+				return strings.NewReader(grafanaWebhookPayload)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "as_file",
+			path: "/grafana/C0123456789?as_file=true&summary=alerts",
+			requestHeaders: map[string]string{
+				"Content-Type": "application/json",
+			},
+			newRequestBody: func(t *testing.T) io.Reader {
+				return strings.NewReader(grafanaWebhookPayload)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "invalid_payload",
+			path: "/grafana/C0123456789",
+			requestHeaders: map[string]string{
+				"Content-Type": "application/json",
+			},
+			newRequestBody: func(t *testing.T) io.Reader {
+				return strings.NewReader("not json")
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.Run(t, g)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package nowpaste
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultIdempotencyTTL is the default duration to remember the result of an idempotency key.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+type IdempotencyEntry struct {
+	IdempotencyKey string
+	Result         *PostResult
+	TTL            time.Time
+}
+
+// IdempotencyStore stores the results of posts by idempotency key, so that duplicate requests are not posted again.
+type IdempotencyStore interface {
+	Get(ctx context.Context, idempotencyKey string) (result *PostResult, ok bool, err error)
+	Set(ctx context.Context, entry IdempotencyEntry) error
+}
+
+type InmemoryIdempotencyStore struct {
+	mu      sync.RWMutex
+	entries map[string]IdempotencyEntry
+	sweptAt time.Time
+}
+
+var _ IdempotencyStore = (*InmemoryIdempotencyStore)(nil)
+
+func NewInmemoryIdempotencyStore() *InmemoryIdempotencyStore {
+	return &InmemoryIdempotencyStore{
+		entries: make(map[string]IdempotencyEntry),
+	}
+}
+
+func (s *InmemoryIdempotencyStore) Get(_ context.Context, idempotencyKey string) (*PostResult, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.entries[idempotencyKey]
+	if !ok || entry.TTL.Before(time.Now()) {
+		return nil, false, nil
+	}
+	return entry.Result, true, nil
+}
+
+func (s *InmemoryIdempotencyStore) Set(_ context.Context, entry IdempotencyEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweptAt = sweepExpired(s.entries, func(e IdempotencyEntry) time.Time { return e.TTL }, s.sweptAt, time.Now())
+	s.entries[entry.IdempotencyKey] = entry
+	return nil
+}
+
+// FileIdempotencyStore persists idempotency keys to a JSON file for single node deployments.
+type FileIdempotencyStore struct {
+	store *fileTTLStore[*PostResult]
+}
+
+var _ IdempotencyStore = (*FileIdempotencyStore)(nil)
+
+func NewFileIdempotencyStore(path string) (*FileIdempotencyStore, error) {
+	store, err := newFileTTLStore[*PostResult](path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileIdempotencyStore{store: store}, nil
+}
+
+func (s *FileIdempotencyStore) Get(_ context.Context, idempotencyKey string) (*PostResult, bool, error) {
+	result, ok := s.store.get(idempotencyKey)
+	return result, ok, nil
+}
+
+func (s *FileIdempotencyStore) Set(_ context.Context, entry IdempotencyEntry) error {
+	return s.store.set(entry.IdempotencyKey, entry.Result, entry.TTL)
+}
+
+// keyedMutex serializes the processing of the same key, e.g. concurrent deliveries of one SNS message.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedMutexEntry
+}
+
+type keyedMutexEntry struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{
+		locks: make(map[string]*keyedMutexEntry),
+	}
+}
+
+// Lock locks the key, and returns the function to unlock it.
+func (m *keyedMutex) Lock(key string) func() {
+	m.mu.Lock()
+	entry, ok := m.locks[key]
+	if !ok {
+		entry = &keyedMutexEntry{}
+		m.locks[key] = entry
+	}
+	entry.refs++
+	m.mu.Unlock()
+	entry.mu.Lock()
+	return func() {
+		entry.mu.Unlock()
+		m.mu.Lock()
+		entry.refs--
+		if entry.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// postContentIdempotently posts the content only once for the idempotency key of the content.
+// For duplicate requests, it returns the result of the original post.
+func (nwp *NowPaste) postContentIdempotently(ctx context.Context, content *Content) (*PostResult, error) {
+	key := content.IdempotencyKey
+	if key == "" || nwp.idempotency == nil {
+		return nwp.postAndRecord(ctx, content)
+	}
+	unlock := nwp.idempotencyLocks.Lock(key)
+	defer unlock()
+	result, ok, err := nwp.idempotency.Get(ctx, key)
+	if err != nil {
+		log.Printf("[warn] get idempotency key `%s`: %s", key, err.Error())
+	}
+	if ok {
+		log.Printf("[info] duplicate request of idempotency key `%s`, skip posting", key)
+		replayed := *result
+		replayed.Duplicate = true
+		return &replayed, nil
+	}
+	result, err = nwp.postAndRecord(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+	if err := nwp.idempotency.Set(ctx, IdempotencyEntry{
+		IdempotencyKey: key,
+		Result:         result,
+		TTL:            time.Now().Add(nwp.idempotencyTTL),
+	}); err != nil {
+		log.Printf("[warn] set idempotency key `%s`: %s", key, err.Error())
+	}
+	return result, nil
+}
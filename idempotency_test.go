@@ -0,0 +1,112 @@
+package nowpaste
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func countPostMessage(count *int64) middleware {
+	return func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/chat.postMessage" {
+				atomic.AddInt64(count, 1)
+			}
+			next(w, r)
+		}
+	}
+}
+
+func TestPostIdempotencyKey(t *testing.T) {
+	var count int64
+	nwp := newMockedNowPaste(t, io.Discard, countPostMessage(&count))
+	post := func(key string) *PostResult {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/?channel=C0123456789", strings.NewReader("cron job finished"))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		nwp.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status: %d", w.Code)
+		}
+		var result PostResult
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return &result
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			post("job-2024-01-02")
+		}()
+	}
+	wg.Wait()
+	if count != 1 {
+		t.Errorf("expected to post once for the same key, posted %d times", count)
+	}
+	result := post("job-2024-01-02")
+	if !result.Duplicate || result.TS != "1503435956.000247" {
+		t.Errorf("expected the original result, got %#v", result)
+	}
+	if result := post("job-2024-01-03"); result.Duplicate {
+		t.Errorf("expected a new post for another key, got %#v", result)
+	}
+	if count != 2 {
+		t.Errorf("expected to post twice, posted %d times", count)
+	}
+}
+
+func TestSNSMessageIdIdempotency(t *testing.T) {
+	var count int64
+	nwp := newMockedNowPaste(t, io.Discard, countPostMessage(&count))
+	handler := nwp.LambdaHandler(func(_ context.Context, _ json.RawMessage) (interface{}, error) {
+		t.Error("fallback called")
+		return nil, nil
+	})
+	event := `{"Records":[{"EventSource":"aws:sns","Sns":{"Type":"Notification","MessageId":"95df01b4-ee98-5cb9-9903-4c221d41eb5e",
+		"TopicArn":"arn:aws:sns:us-east-1:123456789012:topic","Message":"hello",
+		"MessageAttributes":{"channel":{"Type":"String","Value":"C0123456789"}}}}]}`
+	for i := 0; i < 2; i++ {
+		if _, err := handler(context.Background(), json.RawMessage(event)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count != 1 {
+		t.Errorf("expected redelivered SNS message to be posted once, posted %d times", count)
+	}
+}
+
+func TestFileIdempotencyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idempotency.json")
+	store, err := NewFileIdempotencyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	result := &PostResult{ChannelID: "C0123456789", TS: "1503435956.000247", Mode: "message"}
+	if err := store.Set(ctx, IdempotencyEntry{IdempotencyKey: "key", Result: result, TTL: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewFileIdempotencyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := reopened.Get(ctx, "key")
+	if err != nil || !ok || !reflect.DeepEqual(got, result) {
+		t.Errorf("unexpected result: %#v, %v, %v", got, ok, err)
+	}
+}
@@ -0,0 +1,137 @@
+package nowpaste
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/slack-go/slack"
+)
+
+// incomingWebhookPayload is the payload of Slack Incoming Webhooks.
+type incomingWebhookPayload struct {
+	Text        string             `json:"text"`
+	Blocks      json.RawMessage    `json:"blocks"`
+	Attachments []slack.Attachment `json:"attachments"`
+	Username    string             `json:"username"`
+	IconEmoji   string             `json:"icon_emoji"`
+	IconURL     string             `json:"icon_url"`
+	ThreadTS    string             `json:"thread_ts"`
+	Mrkdwn      *bool              `json:"mrkdwn"`
+	UnfurlLinks *bool              `json:"unfurl_links"`
+}
+
+// content returns the content of the payload to post to the channel.
+func (p *incomingWebhookPayload) content(channel string) *Content {
+	return &Content{
+		Channel:     channel,
+		Text:        p.Text,
+		Blocks:      p.Blocks,
+		Attachments: p.Attachments,
+		Username:    p.Username,
+		IconEmoji:   p.IconEmoji,
+		IconURL:     p.IconURL,
+		ThreadTS:    p.ThreadTS,
+		mrkdwn:      p.Mrkdwn,
+		unfurlLinks: p.UnfurlLinks,
+	}
+}
+
+// postIncomingWebhook accepts payloads of Slack Incoming Webhooks, and answers with the same plain text responses as Slack.
+// see also https://api.slack.com/messaging/webhooks#handling_errors
+func (nwp *NowPaste) postIncomingWebhook(w http.ResponseWriter, req *http.Request) {
+	channel, ok := mux.Vars(req)["channel"]
+	if !ok || channel == "" {
+		writeIncomingWebhookError(w, http.StatusNotFound, "channel_not_found")
+		return
+	}
+	defer req.Body.Close()
+	payload, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.Printf("[info] can not read body: %s", err.Error())
+		writeIncomingWebhookError(w, http.StatusBadRequest, "invalid_payload")
+		return
+	}
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		values, err := url.ParseQuery(string(payload))
+		if err != nil {
+			log.Printf("[info] can not read as form: %s", err.Error())
+			writeIncomingWebhookError(w, http.StatusBadRequest, "invalid_payload")
+			return
+		}
+		payload = []byte(values.Get("payload"))
+	}
+	var p incomingWebhookPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		log.Printf("[info] can not read as json: %s", err.Error())
+		writeIncomingWebhookError(w, http.StatusBadRequest, "invalid_payload")
+		return
+	}
+	// the channel of the route is used, as incoming webhooks are bound to a channel.
+	content := p.content(channel)
+	if !content.IsRich() {
+		writeIncomingWebhookError(w, http.StatusBadRequest, "no_text")
+		return
+	}
+	if len(content.Blocks) > 0 {
+		var blocks slack.Blocks
+		if err := json.Unmarshal(content.Blocks, &blocks); err != nil {
+			log.Printf("[info] can not read blocks: %s", err.Error())
+			writeIncomingWebhookError(w, http.StatusBadRequest, "invalid_blocks")
+			return
+		}
+	}
+	if content.Username == "" {
+		content.Username = defaultUsername
+	}
+	if _, err := nwp.postContent(req.Context(), content); err != nil {
+		log.Printf("[error] post incoming webhook: %s", err.Error())
+		writeIncomingWebhookPostError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "ok")
+}
+
+func writeIncomingWebhookError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(status)
+	io.WriteString(w, code)
+}
+
+// writeIncomingWebhookPostError translates the error of posting to the status and error code of Slack Incoming Webhooks.
+func writeIncomingWebhookPostError(w http.ResponseWriter, err error) {
+	var rle *slack.RateLimitedError
+	if errors.As(err, &rle) {
+		w.Header().Set("Retry-After", fmt.Sprintf("%.0f", rle.RetryAfter.Seconds()))
+		writeIncomingWebhookError(w, http.StatusTooManyRequests, "rate_limited")
+		return
+	}
+	if errors.Is(err, ErrChannelNotFound) {
+		writeIncomingWebhookError(w, http.StatusNotFound, "channel_not_found")
+		return
+	}
+	var ser slack.SlackErrorResponse
+	if errors.As(err, &ser) {
+		switch ser.Err {
+		case "channel_not_found":
+			writeIncomingWebhookError(w, http.StatusNotFound, ser.Err)
+			return
+		case "is_archived", "channel_is_archived":
+			writeIncomingWebhookError(w, http.StatusGone, "channel_is_archived")
+			return
+		}
+		if IsPermanentError(err) {
+			writeIncomingWebhookError(w, http.StatusBadRequest, ser.Err)
+			return
+		}
+	}
+	writeIncomingWebhookError(w, http.StatusInternalServerError, "internal_error")
+}
@@ -0,0 +1,114 @@
+package nowpaste
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPostIncomingWebhook(t *testing.T) {
+	channelNotFound := func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/chat.postMessage" && r.FormValue("channel") == "C0000000000" {
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprint(w, `{"ok":false,"error":"channel_not_found"}`)
+				return
+			}
+			next(w, r)
+		}
+	}
+	cases := []struct {
+		name           string
+		path           string
+		contentType    string
+		body           string
+		expectedStatus int
+		expectedBody   string
+		expectedCalls  []string
+	}{
+		{
+			name:           "ok",
+			path:           "/services/C0123456789",
+			contentType:    "application/json",
+			body:           `{"text":"hello","username":"webhook-bot","icon_emoji":":ghost:","thread_ts":"1503435956.000247","mrkdwn":false,"unfurl_links":false}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+			expectedCalls:  []string{"chat.postMessage", "channel=C0123456789", "text=hello", "username=webhook-bot", "thread_ts=1503435956.000247", "mrkdwn=false", "unfurl_links=false"},
+		},
+		{
+			name:           "form",
+			path:           "/incoming-webhook/C0123456789",
+			contentType:    "application/x-www-form-urlencoded",
+			body:           "payload=" + url.QueryEscape(`{"text":"hello from form"}`),
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+			expectedCalls:  []string{"chat.postMessage", "text=hello+from+form", "username=nowpaste"},
+		},
+		{
+			name:           "auto_file",
+			path:           "/services/C0123456789",
+			contentType:    "application/json",
+			body:           `{"text":"` + strings.Repeat("line\\n", 10) + `"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+			expectedCalls:  []string{"files.getUploadURLExternal", "files.completeUploadExternal"},
+		},
+		{
+			name:           "invalid_payload",
+			path:           "/services/C0123456789",
+			contentType:    "application/json",
+			body:           `not json`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid_payload",
+		},
+		{
+			name:           "no_text",
+			path:           "/services/C0123456789",
+			contentType:    "application/json",
+			body:           `{"username":"webhook-bot"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "no_text",
+		},
+		{
+			name:           "invalid_blocks",
+			path:           "/services/C0123456789",
+			contentType:    "application/json",
+			body:           `{"blocks":{"type":"section"}}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid_blocks",
+		},
+		{
+			name:           "channel_not_found",
+			path:           "/services/C0000000000",
+			contentType:    "application/json",
+			body:           `{"text":"hello"}`,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "channel_not_found",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var apiCallLog bytes.Buffer
+			nwp := newMockedNowPaste(t, &apiCallLog, channelNotFound)
+			req := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body))
+			req.Header.Set("Content-Type", c.contentType)
+			w := httptest.NewRecorder()
+			nwp.ServeHTTP(w, req)
+			if w.Code != c.expectedStatus {
+				t.Errorf("unexpected status: got %d, expected %d", w.Code, c.expectedStatus)
+			}
+			if body := w.Body.String(); body != c.expectedBody {
+				t.Errorf("unexpected body: got `%s`, expected `%s`", body, c.expectedBody)
+			}
+			for _, expected := range c.expectedCalls {
+				if !strings.Contains(apiCallLog.String(), expected) {
+					t.Errorf("api calls do not contain `%s`: %s", expected, apiCallLog.String())
+				}
+			}
+		})
+	}
+}
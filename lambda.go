@@ -0,0 +1,184 @@
+package nowpaste
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// LambdaEventHandler is a handler of raw AWS Lambda events.
+type LambdaEventHandler func(ctx context.Context, event json.RawMessage) (interface{}, error)
+
+// LambdaHandler returns a handler of AWS Lambda events.
+// Amazon SNS, Amazon SQS and Amazon EventBridge events are posted directly,
+// and other events (e.g. HTTP requests of Lambda Function URLs) are passed to fallback.
+func (nwp *NowPaste) LambdaHandler(fallback LambdaEventHandler) LambdaEventHandler {
+	return func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+		var probe struct {
+			Records []struct {
+				EventSource string `json:"eventSource"`
+			} `json:"Records"`
+			DetailType *string `json:"detail-type"`
+			Source     string  `json:"source"`
+		}
+		if err := json.Unmarshal(event, &probe); err != nil {
+			return fallback(ctx, event)
+		}
+		if len(probe.Records) > 0 {
+			switch probe.Records[0].EventSource {
+			case "aws:sns":
+				var e events.SNSEvent
+				if err := json.Unmarshal(event, &e); err != nil {
+					return nil, fmt.Errorf("decode sns event: %w", err)
+				}
+				return nil, nwp.HandleSNSEvent(ctx, &e)
+			case "aws:sqs":
+				var e events.SQSEvent
+				if err := json.Unmarshal(event, &e); err != nil {
+					return nil, fmt.Errorf("decode sqs event: %w", err)
+				}
+				return nwp.HandleSQSEvent(ctx, &e)
+			}
+		}
+		if probe.DetailType != nil && probe.Source != "" {
+			var e events.EventBridgeEvent
+			if err := json.Unmarshal(event, &e); err != nil {
+				return nil, fmt.Errorf("decode eventbridge event: %w", err)
+			}
+			return nil, nwp.HandleEventBridgeEvent(ctx, &e)
+		}
+		return fallback(ctx, event)
+	}
+}
+
+// HandleSNSEvent posts the notifications of an Amazon SNS event.
+// It returns an error only for transient failures, so that Lambda retries the invocation.
+func (nwp *NowPaste) HandleSNSEvent(ctx context.Context, e *events.SNSEvent) error {
+	var errs []error
+	for _, record := range e.Records {
+		n := newHTTPNotificationFromSNSEntity(&record.SNS)
+		log.Println("[info] sns event", n.Type, n.TopicArn, n.Subject)
+		content := &Content{}
+		applySNSMessageAttributes(content, n.MessageAttributes)
+		applySNSNotification(content, n)
+		if err := nwp.postLambdaContent(ctx, "lambda-sns", content); err != nil {
+			errs = append(errs, fmt.Errorf("message %s: %w", n.MessageId, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// HandleSQSEvent posts the messages of an Amazon SQS event, and reports messages failed transiently as batch item failures.
+// The message body can be an Amazon SNS notification (without raw message delivery), a content JSON or a text.
+func (nwp *NowPaste) HandleSQSEvent(ctx context.Context, e *events.SQSEvent) (*events.SQSEventResponse, error) {
+	resp := &events.SQSEventResponse{
+		BatchItemFailures: []events.SQSBatchItemFailure{},
+	}
+	for _, record := range e.Records {
+		log.Println("[info] sqs message", record.MessageId, record.EventSourceARN)
+		content := newContentFromSQSMessage(&record)
+		if err := nwp.postLambdaContent(ctx, "lambda-sqs", content); err != nil {
+			log.Printf("[warn] sqs message %s failed: %s", record.MessageId, err.Error())
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: record.MessageId,
+			})
+		}
+	}
+	return resp, nil
+}
+
+// HandleEventBridgeEvent posts an Amazon EventBridge event.
+// If the detail is a rich content JSON, it is posted as is. Otherwise the detail is posted as a code block.
+func (nwp *NowPaste) HandleEventBridgeEvent(ctx context.Context, e *events.EventBridgeEvent) error {
+	log.Println("[info] eventbridge event", e.ID, e.Source, e.DetailType)
+	content := &Content{}
+	if err := json.Unmarshal(e.Detail, content); err != nil || !content.IsRich() {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, e.Detail, "", "  "); err != nil {
+			buf.Reset()
+			buf.Write(e.Detail)
+		}
+		content.Text = buf.String()
+		content.CodeBlockText = true
+		if content.Summary == "" {
+			content.Summary = fmt.Sprintf("%s from %s", e.DetailType, e.Source)
+		}
+	}
+	return nwp.postLambdaContent(ctx, "lambda-eventbridge", content)
+}
+
+func newHTTPNotificationFromSNSEntity(entity *events.SNSEntity) *HTTPNotification {
+	n := &HTTPNotification{
+		Type:             entity.Type,
+		MessageId:        entity.MessageID,
+		TopicArn:         entity.TopicArn,
+		Subject:          entity.Subject,
+		Message:          entity.Message,
+		Timestamp:        entity.Timestamp,
+		SignatureVersion: entity.SignatureVersion,
+		Signature:        entity.Signature,
+		SigningCertURL:   entity.SigningCertURL,
+		UnsubscribeURL:   entity.UnsubscribeURL,
+	}
+	if len(entity.MessageAttributes) > 0 {
+		// attributes of Lambda SNS events are {"Type": "String", "Value": "..."}, same as HTTP notifications.
+		if bs, err := json.Marshal(entity.MessageAttributes); err == nil {
+			if err := json.Unmarshal(bs, &n.MessageAttributes); err != nil {
+				log.Printf("[warn] sns message attributes decode failed: %s", err.Error())
+			}
+		}
+	}
+	return n
+}
+
+func newContentFromSQSMessage(record *events.SQSMessage) *Content {
+	content := &Content{}
+	var n HTTPNotification
+	if err := json.Unmarshal([]byte(record.Body), &n); err == nil && n.Type == "Notification" && n.TopicArn != "" {
+		applySNSMessageAttributes(content, n.MessageAttributes)
+		applySNSNotification(content, &n)
+	} else if err := json.Unmarshal([]byte(record.Body), content); err != nil || !content.IsRich() {
+		content.Text = record.Body
+		content.CodeBlockText = err == nil
+	}
+	attributes := make(map[string]MessageAttribute, len(record.MessageAttributes))
+	for k, v := range record.MessageAttributes {
+		if v.StringValue == nil {
+			continue
+		}
+		attributes[k] = MessageAttribute{Type: v.DataType, Value: *v.StringValue}
+	}
+	applySNSMessageAttributes(content, attributes)
+	return content
+}
+
+// postLambdaContent posts the content, and returns an error only for transient failures.
+// Permanent failures are stored as dead letters if a dead letter store is set.
+func (nwp *NowPaste) postLambdaContent(ctx context.Context, source string, content *Content) error {
+	if content.Channel == "" {
+		content.Channel = nwp.defaultChannel
+	}
+	if content.Username == "" {
+		content.Username = defaultUsername
+	}
+	original := *content
+	_, err := nwp.postContent(ctx, content)
+	if err == nil {
+		return nil
+	}
+	if !IsPermanentError(err) {
+		return err
+	}
+	log.Printf("[warn] %s post failed permanently: %s", source, err.Error())
+	if nwp.deadLetters != nil {
+		if err := nwp.putDeadLetter(ctx, &DeadLetter{Source: source, Content: &original}, err); err != nil {
+			log.Printf("[error] %s", err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package nowpaste
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestLambdaHandler(t *testing.T) {
+	cases := []struct {
+		name          string
+		event         string
+		expectedPost  []string
+		expectedError bool
+		expectedResp  string
+	}{
+		{
+			name: "sns",
+			event: `{"Records":[{"EventSource":"aws:sns","EventVersion":"1.0","Sns":{
+				"Type":"Notification","MessageId":"95df01b4-ee98-5cb9-9903-4c221d41eb5e",
+				"TopicArn":"arn:aws:sns:us-east-1:123456789012:sns-lambda","Subject":"TestInvoke",
+				"Message":"Hello from SNS!","Timestamp":"2019-01-02T12:45:07.000Z",
+				"MessageAttributes":{"channel":{"Type":"String","Value":"C0123456789"},"username":{"Type":"String","Value":"sns-bot"}}
+			}}]}`,
+			expectedPost: []string{"channel=C0123456789", "Hello+from+SNS", "TestInvoke", "username=sns-bot"},
+		},
+		{
+			name: "sqs",
+			event: `{"Records":[
+				{"messageId":"059f36b4-87a3-44ab-83d2-661975830a7d","eventSource":"aws:sqs","body":"hello from sqs",
+				 "messageAttributes":{"channel":{"stringValue":"C0123456789","dataType":"String"}}},
+				{"messageId":"2e1424d4-f796-459a-8184-9c92662be6da","eventSource":"aws:sqs","body":"{\"channel\":\"C0123456789\",\"text\":\"fail\"}"}
+			]}`,
+			expectedPost: []string{"hello+from+sqs"},
+			expectedResp: `{"batchItemFailures":[{"itemIdentifier":"2e1424d4-f796-459a-8184-9c92662be6da"}]}`,
+		},
+		{
+			name: "sqs_sns_envelope",
+			event: `{"Records":[
+				{"messageId":"059f36b4-87a3-44ab-83d2-661975830a7d","eventSource":"aws:sqs",
+				 "body":"{\"Type\":\"Notification\",\"TopicArn\":\"arn:aws:sns:us-east-1:123456789012:topic\",\"Message\":\"hello from sns via sqs\",\"MessageAttributes\":{\"channel\":{\"Type\":\"String\",\"Value\":\"C0123456789\"}}}"}
+			]}`,
+			expectedPost: []string{"channel=C0123456789", "hello+from+sns+via+sqs"},
+			expectedResp: `{"batchItemFailures":[]}`,
+		},
+		{
+			name: "eventbridge",
+			event: `{"version":"0","id":"6a7e8feb-b491-4cf7-a9f1-bf3703467718","detail-type":"EC2 Instance State-change Notification",
+				"source":"aws.ec2","account":"111122223333","time":"2017-12-22T18:43:48Z","region":"us-west-1",
+				"resources":["arn:aws:ec2:us-west-1:123456789012:instance/i-1234567890abcdef0"],
+				"detail":{"instance-id":"i-1234567890abcdef0","state":"terminated"}}`,
+			expectedPost: []string{"channel=C9876543210", "EC2+Instance+State-change+Notification+from+aws.ec2", "terminated"},
+		},
+		{
+			name:          "transient_failure",
+			event:         `{"Records":[{"EventSource":"aws:sns","Sns":{"Type":"Notification","TopicArn":"arn:aws:sns:us-east-1:123456789012:topic","Message":"fail"}}]}`,
+			expectedError: true,
+		},
+	}
+	failOnText := func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.FormValue("text") == "fail" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			next(w, r)
+		}
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var apiCallLog bytes.Buffer
+			nwp := newMockedNowPaste(t, &apiCallLog, failOnText)
+			nwp.SetDefaultChannel("C9876543210")
+			var fallbackCalled bool
+			handler := nwp.LambdaHandler(func(_ context.Context, _ json.RawMessage) (interface{}, error) {
+				fallbackCalled = true
+				return nil, nil
+			})
+			resp, err := handler(context.Background(), json.RawMessage(c.event))
+			if fallbackCalled {
+				t.Error("fallback called")
+			}
+			if (err != nil) != c.expectedError {
+				t.Errorf("unexpected error: %v", err)
+			}
+			for _, expected := range c.expectedPost {
+				if !strings.Contains(apiCallLog.String(), expected) {
+					t.Errorf("posted message does not contain `%s`: %s", expected, apiCallLog.String())
+				}
+			}
+			if c.expectedResp != "" {
+				bs, _ := json.Marshal(resp)
+				if string(bs) != c.expectedResp {
+					t.Errorf("unexpected response: %s", bs)
+				}
+			}
+		})
+	}
+}
+
+func TestLambdaHandlerFallback(t *testing.T) {
+	nwp := newMockedNowPaste(t, io.Discard)
+	var fallbackCalled bool
+	handler := nwp.LambdaHandler(func(_ context.Context, _ json.RawMessage) (interface{}, error) {
+		fallbackCalled = true
+		return events.LambdaFunctionURLResponse{StatusCode: http.StatusOK}, nil
+	})
+	event := `{"version":"2.0","rawPath":"/","requestContext":{"http":{"method":"POST","path":"/"}},"body":"hello"}`
+	if _, err := handler(context.Background(), json.RawMessage(event)); err != nil {
+		t.Fatal(err)
+	}
+	if !fallbackCalled {
+		t.Error("fallback is not called for http event")
+	}
+}
@@ -0,0 +1,269 @@
+package nowpaste
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// DefaultMessageKeyTTL is the default duration to keep the messages of a message key.
+const DefaultMessageKeyTTL = 7 * 24 * time.Hour
+
+// MessageRef is a reference to a posted message.
+type MessageRef struct {
+	ChannelID string `json:"channel"`
+	TS        string `json:"ts"`
+}
+
+type MessageEntry struct {
+	MessageKey string
+	Messages   []MessageRef
+	TTL        time.Time
+}
+
+// MessageStore stores the messages posted with a message key, so that they can be updated or deleted by the key.
+type MessageStore interface {
+	Get(ctx context.Context, messageKey string) (messages []MessageRef, ok bool, err error)
+	Set(ctx context.Context, entry MessageEntry) error
+	Delete(ctx context.Context, messageKey string) error
+}
+
+type InmemoryMessageStore struct {
+	mu      sync.RWMutex
+	entries map[string]MessageEntry
+	sweptAt time.Time
+}
+
+var _ MessageStore = (*InmemoryMessageStore)(nil)
+
+func NewInmemoryMessageStore() *InmemoryMessageStore {
+	return &InmemoryMessageStore{
+		entries: make(map[string]MessageEntry),
+	}
+}
+
+func (s *InmemoryMessageStore) Get(_ context.Context, messageKey string) ([]MessageRef, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.entries[messageKey]
+	if !ok || entry.TTL.Before(time.Now()) {
+		return nil, false, nil
+	}
+	return entry.Messages, true, nil
+}
+
+func (s *InmemoryMessageStore) Set(_ context.Context, entry MessageEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweptAt = sweepExpired(s.entries, func(e MessageEntry) time.Time { return e.TTL }, s.sweptAt, time.Now())
+	s.entries[entry.MessageKey] = entry
+	return nil
+}
+
+func (s *InmemoryMessageStore) Delete(_ context.Context, messageKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, messageKey)
+	return nil
+}
+
+// FileMessageStore persists message keys to a JSON file, so that messages can be updated after restarts.
+type FileMessageStore struct {
+	store *fileTTLStore[[]MessageRef]
+}
+
+var _ MessageStore = (*FileMessageStore)(nil)
+
+func NewFileMessageStore(path string) (*FileMessageStore, error) {
+	store, err := newFileTTLStore[[]MessageRef](path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileMessageStore{store: store}, nil
+}
+
+func (s *FileMessageStore) Get(_ context.Context, messageKey string) ([]MessageRef, bool, error) {
+	messages, ok := s.store.get(messageKey)
+	return messages, ok, nil
+}
+
+func (s *FileMessageStore) Set(_ context.Context, entry MessageEntry) error {
+	return s.store.set(entry.MessageKey, entry.Messages, entry.TTL)
+}
+
+func (s *FileMessageStore) Delete(_ context.Context, messageKey string) error {
+	return s.store.delete(messageKey)
+}
+
+// recordMessage records the posted message for the message key.
+// A message key refers to one message per channel, so the previous message in the same channel is replaced.
+// The posts of a fan-out record their messages concurrently, so the key is locked while its messages are updated.
+func (nwp *NowPaste) recordMessage(ctx context.Context, messageKey string, ref MessageRef) error {
+	unlock := nwp.messageLocks.Lock(messageKey)
+	defer unlock()
+	messages, _, err := nwp.messages.Get(ctx, messageKey)
+	if err != nil {
+		return fmt.Errorf("get messages of key `%s`: %w", messageKey, err)
+	}
+	entry := MessageEntry{
+		MessageKey: messageKey,
+		Messages:   []MessageRef{ref},
+		TTL:        time.Now().Add(nwp.messageKeyTTL),
+	}
+	for _, m := range messages {
+		if m.ChannelID != ref.ChannelID {
+			entry.Messages = append(entry.Messages, m)
+		}
+	}
+	if err := nwp.messages.Set(ctx, entry); err != nil {
+		return fmt.Errorf("set messages of key `%s`: %w", messageKey, err)
+	}
+	return nil
+}
+
+// messageRefs returns the messages addressed by the content, either by channel and ts or by message key.
+func (nwp *NowPaste) messageRefs(ctx context.Context, content *Content) ([]MessageRef, error) {
+	if content.TS != "" {
+		if content.Channel == "" {
+			return nil, ErrChannelRequired
+		}
+		channelID := content.Channel
+		if id, ok, err := nwp.cache.Get(ctx, content.Channel); err == nil && ok {
+			channelID = id
+		}
+		return []MessageRef{{ChannelID: channelID, TS: content.TS}}, nil
+	}
+	if content.MessageKey == "" {
+		return nil, ErrMessageRequired
+	}
+	messages, ok, err := nwp.messages.Get(ctx, content.MessageKey)
+	if err != nil {
+		return nil, fmt.Errorf("get messages of key `%s`: %w", content.MessageKey, err)
+	}
+	if !ok || len(messages) == 0 {
+		return nil, fmt.Errorf("%w: message_key=%s", ErrMessageNotFound, content.MessageKey)
+	}
+	return messages, nil
+}
+
+// updateContent updates the messages addressed by the content with the same formatting as posting.
+func (nwp *NowPaste) updateContent(ctx context.Context, content *Content) (*PostResult, error) {
+	refs, err := nwp.messageRefs(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+	opts, truncated, err := messageOptions(content)
+	if err != nil {
+		return nil, err
+	}
+	var result *PostResult
+	for _, ref := range refs {
+		var channelID, ts string
+		err, _ := nwp.retrier.Do(ctx, func() error {
+			var err error
+			channelID, ts, _, err = nwp.client.UpdateMessageContext(ctx, ref.ChannelID, ref.TS, opts...)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("update message %s in %s: %w", ref.TS, ref.ChannelID, err)
+		}
+		log.Printf("[info] update Message in %s at %s", channelID, ts)
+		if result == nil {
+			result = &PostResult{
+				ChannelID: channelID,
+				TS:        ts,
+				Mode:      postAsMessage,
+				Truncated: truncated,
+			}
+		}
+	}
+	return result, nil
+}
+
+// deleteContent deletes the messages addressed by the content. The message key is forgotten after deleting.
+func (nwp *NowPaste) deleteContent(ctx context.Context, content *Content) error {
+	refs, err := nwp.messageRefs(ctx, content)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		err, _ := nwp.retrier.Do(ctx, func() error {
+			_, _, err := nwp.client.DeleteMessageContext(ctx, ref.ChannelID, ref.TS)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("delete message %s in %s: %w", ref.TS, ref.ChannelID, err)
+		}
+		log.Printf("[info] delete Message in %s at %s", ref.ChannelID, ref.TS)
+	}
+	if content.TS == "" {
+		if err := nwp.messages.Delete(ctx, content.MessageKey); err != nil {
+			log.Printf("[warn] delete messages of key `%s`: %s", content.MessageKey, err.Error())
+		}
+	}
+	return nil
+}
+
+// readMessageContent reads the content of update and delete requests. The ts is also accepted as a query param or a form field.
+func (nwp *NowPaste) readMessageContent(w http.ResponseWriter, req *http.Request) (*Content, bool) {
+	content, ok := nwp.readContent(w, req)
+	if !ok {
+		return nil, false
+	}
+	if content.TS == "" {
+		content.TS = req.FormValue("ts")
+	}
+	return content, true
+}
+
+func (nwp *NowPaste) postUpdate(w http.ResponseWriter, req *http.Request) {
+	content, ok := nwp.readMessageContent(w, req)
+	if !ok {
+		return
+	}
+	if !content.IsRich() {
+		http.Error(w, "`text`, `blocks` or `attachments` is required", http.StatusBadRequest)
+		return
+	}
+	result, err := nwp.updateContent(req.Context(), content)
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+	nwp.writePostResult(w, req, result)
+}
+
+func (nwp *NowPaste) postDelete(w http.ResponseWriter, req *http.Request) {
+	content, ok := nwp.readMessageContent(w, req)
+	if !ok {
+		return
+	}
+	if err := nwp.deleteContent(req.Context(), content); err != nil {
+		writeMessageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, http.StatusText(http.StatusOK))
+}
+
+// writeMessageError writes the error response for the failure of updating or deleting messages.
+func writeMessageError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrChannelRequired) || errors.Is(err, ErrMessageRequired) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var ser slack.SlackErrorResponse
+	if errors.Is(err, ErrMessageNotFound) || (errors.As(err, &ser) && (ser.Err == "message_not_found" || ser.Err == "channel_not_found")) {
+		log.Printf("[info] %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	writePostError(w, err)
+}
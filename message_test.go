@@ -0,0 +1,125 @@
+package nowpaste
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpdateAndDeleteMessage(t *testing.T) {
+	messageAPI := func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/chat.update", "/api/chat.delete":
+				fmt.Fprintf(w, `{"ok":true,"channel":"%s","ts":"%s"}`, r.FormValue("channel"), r.FormValue("ts"))
+			default:
+				next(w, r)
+			}
+		}
+	}
+	var apiCallLog bytes.Buffer
+	nwp := newMockedNowPaste(t, &apiCallLog, messageAPI)
+	do := func(path string, contentType string, body string) int {
+		t.Helper()
+		apiCallLog.Reset()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		nwp.ServeHTTP(w, req)
+		return w.Code
+	}
+	assertCalls := func(expected ...string) {
+		t.Helper()
+		for _, e := range expected {
+			if !strings.Contains(apiCallLog.String(), e) {
+				t.Errorf("api calls do not contain `%s`: %s", e, apiCallLog.String())
+			}
+		}
+	}
+
+	if code := do("/?channel=C0123456789&message_key=deploy-42", "text/plain", "deploy started"); code != http.StatusOK {
+		t.Fatalf("post: unexpected status %d", code)
+	}
+	// the mocked chat.postMessage responds channel C123456 and ts 1503435956.000247
+	if code := do("/messages/update", "application/json", `{"message_key":"deploy-42","text":"deploy finished","summary":"deploy","code_block_text":true}`); code != http.StatusOK {
+		t.Errorf("update: unexpected status %d", code)
+	}
+	assertCalls("chat.update", "channel=C123456", "ts=1503435956.000247", "text=%60%60%60deploy%0A%0Adeploy+finished%60%60%60")
+
+	if code := do("/messages/update?channel=C0000000001&ts=1503435999.000100", "text/plain", "updated by ts"); code != http.StatusOK {
+		t.Errorf("update by ts: unexpected status %d", code)
+	}
+	assertCalls("chat.update", "channel=C0000000001", "ts=1503435999.000100", "text=updated+by+ts")
+
+	if code := do("/messages/delete", "application/x-www-form-urlencoded", "message_key=deploy-42"); code != http.StatusOK {
+		t.Errorf("delete: unexpected status %d", code)
+	}
+	assertCalls("chat.delete", "channel=C123456", "ts=1503435956.000247")
+
+	if code := do("/messages/update", "application/json", `{"message_key":"deploy-42","text":"again"}`); code != http.StatusNotFound {
+		t.Errorf("update deleted: unexpected status %d", code)
+	}
+	if code := do("/messages/delete", "text/plain", ""); code != http.StatusBadRequest {
+		t.Errorf("delete without key: unexpected status %d", code)
+	}
+}
+
+// slowMessageStore widens the window between getting and setting the messages of a key.
+type slowMessageStore struct {
+	MessageStore
+}
+
+func (s slowMessageStore) Get(ctx context.Context, messageKey string) ([]MessageRef, bool, error) {
+	messages, ok, err := s.MessageStore.Get(ctx, messageKey)
+	time.Sleep(10 * time.Millisecond)
+	return messages, ok, err
+}
+
+func TestUpdateMessageFanOut(t *testing.T) {
+	var mu sync.Mutex
+	var updated []string
+	messageAPI := func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/chat.postMessage":
+				fmt.Fprintf(w, `{"ok":true,"channel":"%s","ts":"1503435956.000247"}`, r.FormValue("channel"))
+			case "/api/chat.update":
+				mu.Lock()
+				updated = append(updated, r.FormValue("channel"))
+				mu.Unlock()
+				fmt.Fprintf(w, `{"ok":true,"channel":"%s","ts":"%s"}`, r.FormValue("channel"), r.FormValue("ts"))
+			default:
+				next(w, r)
+			}
+		}
+	}
+	nwp := newMockedNowPaste(t, io.Discard, messageAPI)
+	nwp.SetMessageStore(slowMessageStore{NewInmemoryMessageStore()})
+	channels := []string{"C0000000001", "C0000000002", "C0000000003", "C0000000004"}
+	do := func(path string, contentType string, body string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		nwp.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := do("/?message_key=deploy-42&channel="+strings.Join(channels, ","), "text/plain", "deploy started"); code != http.StatusOK {
+		t.Fatalf("post: unexpected status %d", code)
+	}
+	if code := do("/messages/update", "application/json", `{"message_key":"deploy-42","text":"deploy finished"}`); code != http.StatusOK {
+		t.Fatalf("update: unexpected status %d", code)
+	}
+	slices.Sort(updated)
+	if !slices.Equal(updated, channels) {
+		t.Errorf("expected the messages in every channel to be updated, got %v", updated)
+	}
+}
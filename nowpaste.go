@@ -11,15 +11,14 @@ import (
 	"io"
 	"log"
 	"math/rand"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/aws/arn"
-	"github.com/aws/aws-sdk-go-v2/service/sns"
 	"github.com/gorilla/mux"
 	"github.com/slack-go/slack"
 )
@@ -27,25 +26,82 @@ import (
 const defaultUsername = "nowpaste"
 
 type NowPaste struct {
-	router             *mux.Router
-	client             *slack.Client
-	basicUser          *string
-	basicPass          *string
-	cache              ChannelCache
-	jsonAutoFile       bool
-	serachChannelTypes []string
+	router                  *mux.Router
+	client                  *slack.Client
+	basicUser               *string
+	basicPass               *string
+	cache                   ChannelCache
+	jsonAutoFile            bool
+	serachChannelTypes      []string
+	snsVerifier             *snsVerifier
+	snsSubscriptionPolicies []SNSSubscriptionPolicy
+	confirmSNSSubscription  func(ctx context.Context, n *HTTPNotification, region string) (string, error)
+	snsSubscriptions        SNSSubscriptionStore
+	snsLifecycleNotice      bool
+	snsFailurePolicy        string
+	deadLetters             DeadLetterStore
+	deadLetterLocks         *keyedMutex
+	defaultChannel          string
+	githubWebhookSecret     string
+	githubVerifySignature   bool
+	threads                 ThreadStore
+	threadKeyTTL            time.Duration
+	threadLocks             *keyedMutex
+	messages                MessageStore
+	messageKeyTTL           time.Duration
+	messageLocks            *keyedMutex
+	idempotency             IdempotencyStore
+	idempotencyTTL          time.Duration
+	idempotencyLocks        *keyedMutex
+	schedules               ScheduleStore
+	fanOutConcurrency       int
+	routes                  *RoutingConfig
+	templates               *Templates
+	queue                   QueueStore
+	queueNotify             chan struct{}
+	queueMaxAttempts        int
+	queueRetention          time.Duration
+	queueDrainTimeout       time.Duration
+	retrier                 *retrier
+	limiter                 *rateLimiter
+	ordered                 *orderedQueue
 }
 
 func New(slackToken string) *NowPaste {
-	return newWithClient(slack.New(slackToken))
+	limiter := newRateLimiter(&http.Client{})
+	nwp := newWithClient(slack.New(slackToken, slack.OptionHTTPClient(limiter)))
+	nwp.limiter = limiter
+	return nwp
 }
 
 func newWithClient(client *slack.Client) *NowPaste {
 	nwp := &NowPaste{
-		router:             mux.NewRouter(),
-		client:             client,
-		cache:              NewInmemoryChannelCache(),
-		serachChannelTypes: []string{"public_channel"},
+		router:                 mux.NewRouter(),
+		client:                 client,
+		cache:                  NewInmemoryChannelCache(),
+		serachChannelTypes:     []string{"public_channel"},
+		snsVerifier:            &snsVerifier{fetcher: NewHTTPSNSCertificateFetcher(nil)},
+		confirmSNSSubscription: confirmSNSSubscription,
+		snsSubscriptions:       NewInmemorySNSSubscriptionStore(),
+		snsLifecycleNotice:     true,
+		snsFailurePolicy:       SNSFailurePolicyIgnore,
+		deadLetterLocks:        newKeyedMutex(),
+		githubVerifySignature:  true,
+		threads:                NewInmemoryThreadStore(),
+		threadKeyTTL:           DefaultThreadKeyTTL,
+		threadLocks:            newKeyedMutex(),
+		messages:               NewInmemoryMessageStore(),
+		messageKeyTTL:          DefaultMessageKeyTTL,
+		messageLocks:           newKeyedMutex(),
+		idempotency:            NewInmemoryIdempotencyStore(),
+		idempotencyTTL:         DefaultIdempotencyTTL,
+		idempotencyLocks:       newKeyedMutex(),
+		fanOutConcurrency:      DefaultFanOutConcurrency,
+		queueNotify:            make(chan struct{}, 1),
+		queueMaxAttempts:       DefaultQueueMaxAttempts,
+		queueRetention:         DefaultQueueRetention,
+		queueDrainTimeout:      DefaultQueueDrainTimeout,
+		retrier:                newRetrier(DefaultRetryMaxAttempts, DefaultRetryBudget),
 	}
 	nwp.setRoute()
 	return nwp
@@ -64,13 +120,227 @@ func (nwp *NowPaste) SetCache(cache ChannelCache) {
 	nwp.cache = cache
 }
 
+// SetThreadStore sets the store of thread keys, which maps a thread key to the parent message.
+func (nwp *NowPaste) SetThreadStore(store ThreadStore) {
+	nwp.threads = store
+}
+
+// SetThreadKeyTTL sets the duration to keep the parent message of a thread key.
+func (nwp *NowPaste) SetThreadKeyTTL(ttl time.Duration) {
+	nwp.threadKeyTTL = ttl
+}
+
+// SetMessageStore sets the store of message keys, which maps a message key to the posted messages.
+func (nwp *NowPaste) SetMessageStore(store MessageStore) {
+	nwp.messages = store
+}
+
+// SetMessageKeyTTL sets the duration to keep the messages of a message key.
+func (nwp *NowPaste) SetMessageKeyTTL(ttl time.Duration) {
+	nwp.messageKeyTTL = ttl
+}
+
+// SetIdempotencyStore sets the store of idempotency keys. If nil, requests are not deduplicated.
+func (nwp *NowPaste) SetIdempotencyStore(store IdempotencyStore) {
+	nwp.idempotency = store
+}
+
+// SetIdempotencyTTL sets the duration to remember the result of an idempotency key.
+func (nwp *NowPaste) SetIdempotencyTTL(ttl time.Duration) {
+	nwp.idempotencyTTL = ttl
+}
+
+// SetScheduleStore sets the store of scheduled jobs, which holds the contents Slack can not schedule until RunScheduler posts them.
+// If nil (default), such contents are rejected with ErrScheduleNotSupported.
+func (nwp *NowPaste) SetScheduleStore(store ScheduleStore) {
+	nwp.schedules = store
+}
+
+// SetFanOutConcurrency sets the number of channels posted concurrently for a content with multiple channels.
+func (nwp *NowPaste) SetFanOutConcurrency(n int) {
+	nwp.fanOutConcurrency = n
+}
+
+// SetOrderedDelivery enables or disables the ordered delivery, which posts the contents to the same channel in the order of arrival.
+// The contents to different channels are still posted in parallel.
+func (nwp *NowPaste) SetOrderedDelivery(enabled bool) {
+	if enabled {
+		nwp.ordered = newOrderedQueue()
+	} else {
+		nwp.ordered = nil
+	}
+}
+
+// SetRoutingConfig sets the routing config, which routes requests without explicit channels to channels.
+func (nwp *NowPaste) SetRoutingConfig(config *RoutingConfig) error {
+	if config != nil {
+		if err := config.Validate(); err != nil {
+			return err
+		}
+	}
+	if config != nil && len(config.Templates) > 0 {
+		if nwp.templates == nil {
+			nwp.templates = NewTemplates()
+		}
+		for name, text := range config.Templates {
+			if err := nwp.templates.Add(name, text); err != nil {
+				return err
+			}
+		}
+	}
+	nwp.routes = config
+	return nil
+}
+
+// SetTemplates sets the templates to render JSON payloads, selected by the `template` query param or the routing rule.
+func (nwp *NowPaste) SetTemplates(templates *Templates) {
+	nwp.templates = templates
+}
+
+// SetQueueStore sets the queue store and enables the async mode, where contents are enqueued and delivered by RunQueueWorkers.
+// If nil, contents are posted in the requests.
+func (nwp *NowPaste) SetQueueStore(store QueueStore) {
+	nwp.queue = store
+}
+
+// SetQueueMaxAttempts sets the max number of attempts to deliver a queued job, which also limits the attempts of the jobs of the scheduler.
+func (nwp *NowPaste) SetQueueMaxAttempts(n int) {
+	nwp.queueMaxAttempts = n
+}
+
+// SetQueueRetention sets the duration to keep the finished jobs for the job status.
+func (nwp *NowPaste) SetQueueRetention(d time.Duration) {
+	nwp.queueRetention = d
+}
+
+// SetQueueDrainTimeout sets the duration to deliver the queued jobs after RunQueueWorkers is canceled.
+func (nwp *NowPaste) SetQueueDrainTimeout(d time.Duration) {
+	nwp.queueDrainTimeout = d
+}
+
+// SetRetryMaxAttempts sets the max number of attempts of a Slack API call failed transiently, including the first attempt.
+func (nwp *NowPaste) SetRetryMaxAttempts(n int) {
+	nwp.retrier.maxAttempts = n
+}
+
+// SetRetryBudget sets the total duration to retry a Slack API call, including the waits before the attempts except for Retry-After.
+func (nwp *NowPaste) SetRetryBudget(d time.Duration) {
+	nwp.retrier.budget = d
+}
+
+// SetRetryMaxRateLimitWait sets the max total duration to wait for Retry-After of a rate limited Slack API call.
+func (nwp *NowPaste) SetRetryMaxRateLimitWait(d time.Duration) {
+	nwp.retrier.maxRateLimitWait = d
+}
+
+// SetRateLimit enables or disables the process-wide rate limiter of Slack API calls, which is enabled by default.
+func (nwp *NowPaste) SetRateLimit(enabled bool) {
+	if nwp.limiter != nil {
+		nwp.limiter.setEnabled(enabled)
+	}
+}
+
 func (nwp *NowPaste) SetJSONAutoFile(b bool) {
 	nwp.jsonAutoFile = b
 }
 
+// SetSNSSignatureVerification enables or disables signature verification of Amazon SNS messages.
+// When enabled, messages that can not be verified (including raw message delivery) are rejected.
+func (nwp *NowPaste) SetSNSSignatureVerification(enabled bool) {
+	if !enabled {
+		nwp.snsVerifier = nil
+		return
+	}
+	if nwp.snsVerifier == nil {
+		nwp.snsVerifier = &snsVerifier{fetcher: NewHTTPSNSCertificateFetcher(nil)}
+	}
+}
+
+// SetSNSCertificateFetcher replaces the fetcher of Amazon SNS signing certificates, and enables signature verification.
+func (nwp *NowPaste) SetSNSCertificateFetcher(fetcher SNSCertificateFetcher) {
+	nwp.snsVerifier = &snsVerifier{fetcher: fetcher}
+}
+
+// SetSNSSubscriptionPolicies restricts which Amazon SNS topics can subscribe to which channels.
+// If no policies are set, all subscriptions are confirmed automatically.
+func (nwp *NowPaste) SetSNSSubscriptionPolicies(policies []SNSSubscriptionPolicy) error {
+	for i := range policies {
+		if err := policies[i].Validate(); err != nil {
+			return fmt.Errorf("sns subscription policies[%d]: %w", i, err)
+		}
+	}
+	nwp.snsSubscriptionPolicies = policies
+	return nil
+}
+
+// SetSNSSubscriptionStore sets the store of Amazon SNS subscriptions known by nowpaste.
+func (nwp *NowPaste) SetSNSSubscriptionStore(store SNSSubscriptionStore) {
+	nwp.snsSubscriptions = store
+}
+
+// SetSNSLifecycleNotice enables or disables posting notices when Amazon SNS subscriptions are confirmed or unsubscribed.
+// It can be overridden by the `lifecycle_notice` query param.
+func (nwp *NowPaste) SetSNSLifecycleNotice(enabled bool) {
+	nwp.snsLifecycleNotice = enabled
+}
+
+// SetSNSFailurePolicy sets how the Amazon SNS endpoint answers when posting to Slack fails.
+// policy is one of SNSFailurePolicyIgnore (default), SNSFailurePolicyRetry and SNSFailurePolicyDeadLetter.
+func (nwp *NowPaste) SetSNSFailurePolicy(policy string) error {
+	switch policy {
+	case SNSFailurePolicyIgnore, SNSFailurePolicyRetry:
+	case SNSFailurePolicyDeadLetter:
+		if nwp.deadLetters == nil {
+			return errors.New("sns failure policy `dead-letter` requires dead letter store")
+		}
+	default:
+		return fmt.Errorf("unknown sns failure policy `%s`", policy)
+	}
+	nwp.snsFailurePolicy = policy
+	return nil
+}
+
+// SetDeadLetterStore sets the store of contents which nowpaste failed to post.
+func (nwp *NowPaste) SetDeadLetterStore(store DeadLetterStore) {
+	nwp.deadLetters = store
+}
+
+// SetDefaultChannel sets the channel for AWS Lambda events which do not specify the channel.
+func (nwp *NowPaste) SetDefaultChannel(channel string) {
+	nwp.defaultChannel = channel
+}
+
+// SetGitHubWebhookSecret sets the secret to verify the X-Hub-Signature-256 header of GitHub webhooks.
+func (nwp *NowPaste) SetGitHubWebhookSecret(secret string) {
+	nwp.githubWebhookSecret = secret
+}
+
+// SetGitHubSignatureVerification enables or disables signature verification of GitHub webhooks.
+// When enabled without the secret, GitHub webhooks are rejected.
+func (nwp *NowPaste) SetGitHubSignatureVerification(enabled bool) {
+	nwp.githubVerifySignature = enabled
+}
+
 func (nwp *NowPaste) setRoute() {
 	nwp.router.HandleFunc("/", nwp.postDefault).Methods(http.MethodPost)
+	nwp.router.HandleFunc("/amazon-sns", nwp.postSNS).Methods(http.MethodPost)
 	nwp.router.HandleFunc("/amazon-sns/{channel}", nwp.postSNS).Methods(http.MethodPost)
+	nwp.router.HandleFunc("/alertmanager/{channel}", nwp.postAlertmanager).Methods(http.MethodPost)
+	nwp.router.HandleFunc("/grafana/{channel}", nwp.postGrafana).Methods(http.MethodPost)
+	nwp.router.HandleFunc("/github/{channel}", nwp.postGitHub).Methods(http.MethodPost)
+	nwp.router.HandleFunc("/services/{channel}", nwp.postIncomingWebhook).Methods(http.MethodPost)
+	nwp.router.HandleFunc("/incoming-webhook/{channel}", nwp.postIncomingWebhook).Methods(http.MethodPost)
+	nwp.router.HandleFunc("/messages/update", nwp.postUpdate).Methods(http.MethodPost)
+	nwp.router.HandleFunc("/messages/delete", nwp.postDelete).Methods(http.MethodPost)
+	nwp.router.HandleFunc("/scheduled", nwp.getScheduled).Methods(http.MethodGet)
+	nwp.router.HandleFunc("/scheduled/delete", nwp.postScheduledDelete).Methods(http.MethodPost)
+	nwp.router.HandleFunc("/jobs/{id}", nwp.getJob).Methods(http.MethodGet)
+	nwp.router.HandleFunc("/admin/amazon-sns/subscriptions", nwp.getSNSSubscriptions).Methods(http.MethodGet)
+	nwp.router.HandleFunc("/admin/rate-limits", nwp.getRateLimits).Methods(http.MethodGet)
+	nwp.router.HandleFunc("/admin/dead-letters", nwp.getDeadLetters).Methods(http.MethodGet)
+	nwp.router.HandleFunc("/admin/dead-letters/purge", nwp.postDeadLettersPurge).Methods(http.MethodPost)
+	nwp.router.HandleFunc("/admin/dead-letters/{id}", nwp.getDeadLetter).Methods(http.MethodGet)
+	nwp.router.HandleFunc("/admin/dead-letters/{id}/redrive", nwp.postDeadLetterRedrive).Methods(http.MethodPost)
 }
 
 func (nwp *NowPaste) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -111,10 +381,32 @@ func (nwp *NowPaste) newContent(req *http.Request) *Content {
 			log.Printf("[warn] as_message query param parse failed: %s", err.Error())
 		}
 	}
+	content.ThreadTS = req.URL.Query().Get("thread_ts")
+	content.ThreadKey = req.URL.Query().Get("thread_key")
+	content.MessageKey = req.URL.Query().Get("message_key")
+	content.IdempotencyKey = req.Header.Get("Idempotency-Key")
+	if replyBroadcast := req.URL.Query().Get("reply_broadcast"); replyBroadcast != "" {
+		b, err := strconv.ParseBool(replyBroadcast)
+		if err == nil {
+			content.ReplyBroadcast = b
+		} else {
+			log.Printf("[warn] reply_broadcast query param parse failed: %s", err.Error())
+		}
+	}
+	if postAt := req.URL.Query().Get("post_at"); postAt != "" {
+		t, err := ParseScheduleTime(postAt)
+		if err == nil {
+			content.PostAt = t
+		} else {
+			log.Printf("[warn] post_at query param parse failed: %s", err.Error())
+		}
+	}
 	return content
 }
 
-func (nwp *NowPaste) postDefault(w http.ResponseWriter, req *http.Request) {
+// readContent reads the content from the query params and the body of the request, in the format of `POST /`.
+// It returns false after writing an error response.
+func (nwp *NowPaste) readContent(w http.ResponseWriter, req *http.Request) (*Content, bool) {
 	defer req.Body.Close()
 	content := nwp.newContent(req)
 	username := req.URL.Query().Get("username")
@@ -135,8 +427,12 @@ func (nwp *NowPaste) postDefault(w http.ResponseWriter, req *http.Request) {
 			codeBlockText = b
 		}
 	}
+	channel := req.URL.Query().Get("channel")
+	if email := req.URL.Query().Get("email"); channel == "" && email != "" {
+		channel = directMessagePrefixEmail + email
+	}
 	content.Merge(&Content{
-		Channel:       req.URL.Query().Get("channel"),
+		Channel:       channel,
 		Username:      username,
 		EscapeText:    escapeText,
 		CodeBlockText: codeBlockText,
@@ -146,44 +442,38 @@ func (nwp *NowPaste) postDefault(w http.ResponseWriter, req *http.Request) {
 	})
 	contentType := req.Header.Get("Content-Type")
 	log.Printf("[debug] Content-Type: %s", contentType)
-	switch strings.ToLower(contentType) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+	switch mediaType {
 	case "application/x-www-form-urlencoded":
-		username := req.FormValue("username")
-		if username == "" {
-			username = defaultUsername
-		}
-		escapeTextStr := req.FormValue("escape_text")
-		var escapeText bool
-		if escapeTextStr != "" {
-			if b, err := strconv.ParseBool(escapeTextStr); err == nil {
-				escapeText = b
-			}
+		content.Merge(newContentFromForm(req))
+	case "multipart/form-data":
+		if err := req.ParseMultipartForm(maxMultipartMemory); err != nil {
+			log.Printf("[info] can not read as multipart form: %s", err.Error())
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return nil, false
 		}
-		codeBlockTextStr := req.FormValue("code_block_text")
-		var codeBlockText bool
-		if codeBlockTextStr != "" {
-			if b, err := strconv.ParseBool(codeBlockTextStr); err == nil {
-				codeBlockText = b
-			}
+		// the files are read into the content, so the parts spilled to temporary files are removed.
+		defer req.MultipartForm.RemoveAll()
+		content.Merge(newContentFromForm(req))
+		files, err := readMultipartFiles(req, "file")
+		if err != nil {
+			log.Printf("[info] can not read multipart files: %s", err.Error())
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return nil, false
 		}
-		content.Merge(&Content{
-			Channel:       req.FormValue("channel"),
-			Text:          req.FormValue("text"),
-			Username:      username,
-			IconEmoji:     req.FormValue("icon_emoji"),
-			IconURL:       req.FormValue("icon_url"),
-			EscapeText:    escapeText,
-			CodeBlockText: codeBlockText,
-			Summary:       req.FormValue("summary"),
-		})
+		content.Files = files
 	case "application/json":
 		var buf bytes.Buffer
 		decoder := json.NewDecoder(io.TeeReader(req.Body, &buf))
 		if err := decoder.Decode(content); err != nil {
 			log.Printf("[info] can not read as json: %s", err.Error())
 			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-			return
+			return nil, false
 		}
+		content.payload = buf.Bytes()
 		if !content.IsRich() {
 			content.Text = buf.String()
 			content.CodeBlockText = true
@@ -202,30 +492,211 @@ func (nwp *NowPaste) postDefault(w http.ResponseWriter, req *http.Request) {
 		if err != nil {
 			log.Printf("[info] can not read body: %s", err.Error())
 			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-			return
+			return nil, false
 		}
-		content.Merge(&Content{
-			Text: string(bs),
-		})
+		if utf8.Valid(bs) {
+			content.Merge(&Content{
+				Text: string(bs),
+			})
+		} else {
+			filename := req.URL.Query().Get("filename")
+			if filename == "" {
+				filename = "file" + DetermineExtension(bs)
+			}
+			content.Files = []ContentFile{{Filename: filename, Data: bs}}
+		}
+	}
+	return content, true
+}
+
+func (nwp *NowPaste) postDefault(w http.ResponseWriter, req *http.Request) {
+	content, ok := nwp.readContent(w, req)
+	if !ok {
+		return
+	}
+	if content.Channel == "" {
+		nwp.route(content, newRoutingRequest(req, content.payload))
 	}
 	if content.Channel == "" {
 		http.Error(w, "query param `channel` is required", http.StatusBadRequest)
 		return
 	}
-	if err := nwp.postContent(req.Context(), content); err != nil {
-		var rle *slack.RateLimitedError
-		if errors.As(err, &rle) {
-			log.Printf("[warn] rate limit: %s", err.Error())
-			w.Header().Add("Retry-After", rle.RetryAfter.String())
-			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	if name := templateName(content, req.URL.Query().Get("template")); name != "" {
+		if err := nwp.applyTemplate(content, name); err != nil {
+			log.Printf("[info] %s", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		log.Printf("[error] post failed: %s", err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+	if nwp.queue != nil {
+		nwp.postAsync(w, req, "default", content, newRequestMetadata(req))
 		return
 	}
+	result, err := nwp.postContent(req.Context(), content)
+	if err != nil {
+		writePostError(w, err)
+		return
+	}
+	nwp.writePostResult(w, req, result)
+}
+
+// maxMultipartMemory is the max memory to parse multipart forms, the rest of files are stored on temporary files.
+const maxMultipartMemory = 32 << 20
+
+// newContentFromForm returns the content from the form values of the request.
+func newContentFromForm(req *http.Request) *Content {
+	username := req.FormValue("username")
+	if username == "" {
+		username = defaultUsername
+	}
+	escapeTextStr := req.FormValue("escape_text")
+	var escapeText bool
+	if escapeTextStr != "" {
+		if b, err := strconv.ParseBool(escapeTextStr); err == nil {
+			escapeText = b
+		}
+	}
+	codeBlockTextStr := req.FormValue("code_block_text")
+	var codeBlockText bool
+	if codeBlockTextStr != "" {
+		if b, err := strconv.ParseBool(codeBlockTextStr); err == nil {
+			codeBlockText = b
+		}
+	}
+	var replyBroadcast bool
+	if replyBroadcastStr := req.FormValue("reply_broadcast"); replyBroadcastStr != "" {
+		if b, err := strconv.ParseBool(replyBroadcastStr); err == nil {
+			replyBroadcast = b
+		}
+	}
+	var postAt *ScheduleTime
+	if postAtStr := req.FormValue("post_at"); postAtStr != "" {
+		if t, err := ParseScheduleTime(postAtStr); err == nil {
+			postAt = t
+		} else {
+			log.Printf("[warn] post_at form value parse failed: %s", err.Error())
+		}
+	}
+	channel := req.FormValue("channel")
+	if email := req.FormValue("email"); channel == "" && email != "" {
+		channel = directMessagePrefixEmail + email
+	}
+	return &Content{
+		Channel:        channel,
+		Text:           req.FormValue("text"),
+		Username:       username,
+		IconEmoji:      req.FormValue("icon_emoji"),
+		IconURL:        req.FormValue("icon_url"),
+		EscapeText:     escapeText,
+		CodeBlockText:  codeBlockText,
+		Summary:        req.FormValue("summary"),
+		Filename:       req.FormValue("filename"),
+		ThreadTS:       req.FormValue("thread_ts"),
+		ThreadKey:      req.FormValue("thread_key"),
+		MessageKey:     req.FormValue("message_key"),
+		IdempotencyKey: req.FormValue("idempotency_key"),
+		ReplyBroadcast: replyBroadcast,
+		PostAt:         postAt,
+	}
+}
+
+// readMultipartFiles reads the files of the multipart form field with their original filenames.
+func readMultipartFiles(req *http.Request, field string) ([]ContentFile, error) {
+	if req.MultipartForm == nil {
+		return nil, nil
+	}
+	headers := req.MultipartForm.File[field]
+	files := make([]ContentFile, 0, len(headers))
+	for _, header := range headers {
+		f, err := header.Open()
+		if err != nil {
+			return nil, fmt.Errorf("open %s: %w", header.Filename, err)
+		}
+		data, err := io.ReadAll(f)
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", header.Filename, err)
+		}
+		files = append(files, ContentFile{Filename: header.Filename, Data: data})
+	}
+	return files, nil
+}
+
+// writePostResult writes the response for a successful post.
+// If the client accepts JSON, the response describes what was posted, including the permalink.
+func (nwp *NowPaste) writePostResult(w http.ResponseWriter, req *http.Request, result *PostResult) {
+	if !acceptsJSON(req) {
+		if len(result.Results) > 0 {
+			writeChannelResults(w, result.Results)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, http.StatusText(http.StatusOK))
+		return
+	}
+	if result.Permalink == "" {
+		nwp.fillPermalink(req.Context(), result)
+	}
+	for _, r := range result.Results {
+		if r.Result != nil && r.Result.Permalink == "" {
+			nwp.fillPermalink(req.Context(), r.Result)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	io.WriteString(w, http.StatusText(http.StatusOK))
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("[warn] write post result: %s", err.Error())
+	}
+}
+
+func (nwp *NowPaste) fillPermalink(ctx context.Context, result *PostResult) {
+	if result.TS == "" && result.FileID != "" {
+		result.TS, result.Permalink = nwp.fileShareTS(ctx, result.FileID, result.ChannelID)
+		return
+	}
+	if result.TS == "" {
+		return
+	}
+	permalink, err := nwp.client.GetPermalinkContext(ctx, &slack.PermalinkParameters{
+		Channel: result.ChannelID,
+		Ts:      result.TS,
+	})
+	if err != nil {
+		log.Printf("[warn] get permalink of %s in %s: %s", result.TS, result.ChannelID, err.Error())
+		return
+	}
+	result.Permalink = permalink
+}
+
+// acceptsJSON reports whether the Accept header of the request includes application/json.
+func acceptsJSON(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// writePostError writes the error response for the failure of postContent.
+func writePostError(w http.ResponseWriter, err error) {
+	var rle *slack.RateLimitedError
+	if errors.As(err, &rle) {
+		log.Printf("[warn] rate limit: %s", err.Error())
+		w.Header().Add("Retry-After", rle.RetryAfter.String())
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+	if errors.Is(err, ErrScheduleNotSupported) {
+		log.Printf("[info] %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("[error] post failed: %s", err.Error())
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
 // https://docs.aws.amazon.com/sns/latest/dg/json-formats.html
@@ -255,83 +726,72 @@ func (nwp *NowPaste) postSNS(w http.ResponseWriter, req *http.Request) {
 	var n HTTPNotification
 	var buf bytes.Buffer
 	decoder := json.NewDecoder(io.TeeReader(req.Body, &buf))
+	var raw bool
 	if err := decoder.Decode(&n); err != nil || n.Type == "" {
+		raw = true
 		n.Message = buf.String()
 		log.Println("[info] maybe raw message derived from SNS")
 		n.MessageId = req.Header.Get("X-Amz-Sns-Message-Id")
 		n.Type = req.Header.Get("X-Amz-Sns-Message-Type")
 		n.TopicArn = req.Header.Get("X-Amz-Sns-Topic-Arn")
 	}
-	log.Println("[info] sns", n.Type, n.TopicArn, n.Subject)
-	vars := mux.Vars(req)
-	channel, ok := vars["channel"]
-	if !ok || channel == "" {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-		return
+	if nwp.snsVerifier != nil {
+		if err := nwp.snsVerifier.Verify(req.Context(), buf.Bytes()); err != nil {
+			log.Printf("[warn] sns message verification failed: %s", err.Error())
+			if raw {
+				log.Println("[warn] raw message delivery is not signed, disable raw message delivery or the verification by -sns-verify-signature=false")
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
 	}
+	log.Println("[info] sns", n.Type, n.TopicArn, n.Subject)
 	content := nwp.newContent(req)
-	if n.MessageAttributes != nil {
-		for k, v := range n.MessageAttributes {
-			log.Println("[debug] message attribute", k, v.Value)
-			if v.Type != "String" {
-				continue
-			}
-			switch k {
-			case "as_file":
-				if b, err := strconv.ParseBool(v.Value); err == nil {
-					content.AsFile = b
-				}
-			case "as_message":
-				if b, err := strconv.ParseBool(v.Value); err == nil {
-					content.AsMessage = b
-				}
-			case "filename":
-				content.Filename = v.Value
-			case "icon_emoji":
-				content.IconEmoji = v.Value
-			case "icon_url":
-				content.IconURL = v.Value
-			case "username":
-				content.Username = v.Value
-			}
+	applySNSMessageAttributes(content, n.MessageAttributes)
+	channel := mux.Vars(req)["channel"]
+	if channel == "" {
+		// the channel message attribute comes first, then the routing rules.
+		if content.Channel == "" {
+			nwp.route(content, newSNSRoutingRequest(req, &n))
 		}
+		channel = content.Channel
+	}
+	if channel == "" && n.Type != "Notification" {
+		// subscription lifecycle messages have neither subject nor message fields to be routed.
+		channel = nwp.defaultChannel
+	}
+	if channel == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
 	}
+	var isLifecycleNotice bool
 	switch n.Type {
 	case "SubscriptionConfirmation":
-		arnObj, err := arn.Parse(n.TopicArn)
-		if err != nil {
-			log.Printf("[error] topic ARN `%s` parse failed: %s", n.TopicArn, err.Error())
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		mode, ok := nwp.handleSNSSubscriptionConfirmation(w, req, &n, channel, content)
+		if !ok {
 			return
 		}
-		client := sns.New(sns.Options{Region: arnObj.Region})
-		_, err = client.ConfirmSubscription(req.Context(), &sns.ConfirmSubscriptionInput{
-			Token:                     aws.String(n.Token),
-			TopicArn:                  aws.String(n.TopicArn),
-			AuthenticateOnUnsubscribe: aws.String("no"),
-		})
-		if err != nil {
-			log.Println("[warn]", err)
-			break
-		}
-		var out bytes.Buffer
-		fmt.Fprintf(&out, "this message posted by nowpaste\n\n")
-		fmt.Fprintf(&out, "%s from %s\n", n.Type, n.TopicArn)
-		if subscriptionArn := req.Header.Get("x-amz-sns-subscription-arn"); subscriptionArn != "" {
-			fmt.Fprintf(&out, "Subscribe by %s\n", subscriptionArn)
-		}
-
-		fmt.Fprintf(&out, "You have chosen to subscribe to the topic %s.\n", n.TopicArn)
-		fmt.Fprintln(&out, "This Subscription was automatically confirmed by nowpaste.")
-		content.CodeBlockText = true
-		content.Text = out.String()
+		isLifecycleNotice = mode == SNSConfirmAuto
+	case "UnsubscribeConfirmation":
+		nwp.handleSNSUnsubscribeConfirmation(req, &n, channel, content)
+		isLifecycleNotice = true
 	case "Notification":
-		decoder := json.NewDecoder(strings.NewReader(n.Message))
-		if err := decoder.Decode(&content); err != nil {
-			content.Text = strings.Trim(n.Message, "\"")
+		if subscriptionArn := req.Header.Get("x-amz-sns-subscription-arn"); subscriptionArn != "" {
+			nwp.putSNSSubscription(req.Context(), SNSSubscription{
+				Channel:         channel,
+				TopicArn:        n.TopicArn,
+				SubscriptionArn: subscriptionArn,
+				Status:          SNSSubscriptionStatusConfirmed,
+			})
 		}
-		if !content.IsRich() {
-			content.Text = strings.Trim(n.Message, "\"")
+		applySNSNotification(content, &n)
+		if name := templateName(content, req.URL.Query().Get("template")); name != "" {
+			if json.Valid([]byte(n.Message)) {
+				content.payload = []byte(n.Message)
+			}
+			if err := nwp.applyTemplate(content, name); err != nil {
+				log.Printf("[warn] %s, post the message as is", err.Error())
+			}
 		}
 		if content.Text != "" {
 			escapeTextStr := req.URL.Query().Get("escape_text")
@@ -347,9 +807,16 @@ func (nwp *NowPaste) postSNS(w http.ResponseWriter, req *http.Request) {
 				}
 			}
 		}
-		if content.Summary == "" && n.Subject != "" {
-			content.Summary = n.Subject
-		}
+	default:
+		log.Printf("[warn] unknown sns message type `%s`", n.Type)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if isLifecycleNotice && !nwp.snsLifecycleNoticeEnabled(req) {
+		log.Printf("[info] %s notice for channel `%s` is suppressed", n.Type, channel)
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, http.StatusText(http.StatusOK))
+		return
 	}
 	content.Channel = channel
 	if content.IconEmoji == "" && content.IconURL == "" {
@@ -359,7 +826,13 @@ func (nwp *NowPaste) postSNS(w http.ResponseWriter, req *http.Request) {
 	if content.Username == "" {
 		content.Username = req.URL.Query().Get("username")
 	}
-	if err := nwp.postContent(req.Context(), content); err != nil {
+	if nwp.queue != nil {
+		nwp.postAsync(w, req, "amazon-sns", content, newSNSRequestMetadata(req, &n))
+		return
+	}
+	original := *content
+	result, err := nwp.postContent(req.Context(), content)
+	if err != nil {
 		var rle *slack.RateLimitedError
 		if errors.As(err, &rle) {
 			log.Printf("[warn] rate limit: %s", err.Error())
@@ -367,27 +840,137 @@ func (nwp *NowPaste) postSNS(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
 			return
 		}
-		log.Printf("[warn] %s post failed: %s", n.TopicArn, err.Error())
+		nwp.handleSNSFailure(w, req, &n, &original, err)
+		return
+	}
+	nwp.writePostResult(w, req, result)
+}
+
+// applySNSMessageAttributes applies String type message attributes to content.
+func applySNSMessageAttributes(content *Content, attributes map[string]MessageAttribute) {
+	for k, v := range attributes {
+		log.Println("[debug] message attribute", k, v.Value)
+		if v.Type != "String" {
+			continue
+		}
+		switch k {
+		case "as_file":
+			if b, err := strconv.ParseBool(v.Value); err == nil {
+				content.AsFile = b
+			}
+		case "as_message":
+			if b, err := strconv.ParseBool(v.Value); err == nil {
+				content.AsMessage = b
+			}
+		case "channel":
+			content.Channel = v.Value
+		case "email":
+			if content.Channel == "" {
+				content.Channel = directMessagePrefixEmail + v.Value
+			}
+		case "filename":
+			content.Filename = v.Value
+		case "icon_emoji":
+			content.IconEmoji = v.Value
+		case "icon_url":
+			content.IconURL = v.Value
+		case "username":
+			content.Username = v.Value
+		case "thread_ts":
+			content.ThreadTS = v.Value
+		case "thread_key":
+			content.ThreadKey = v.Value
+		case "message_key":
+			content.MessageKey = v.Value
+		case "idempotency_key":
+			content.IdempotencyKey = v.Value
+		case "reply_broadcast":
+			if b, err := strconv.ParseBool(v.Value); err == nil {
+				content.ReplyBroadcast = b
+			}
+		case "post_at":
+			if t, err := ParseScheduleTime(v.Value); err == nil {
+				content.PostAt = t
+			}
+		}
+	}
+}
+
+// applySNSNotification decodes the message of the notification into content.
+// If the message is not a rich content JSON, it is posted as text.
+func applySNSNotification(content *Content, n *HTTPNotification) {
+	decoder := json.NewDecoder(strings.NewReader(n.Message))
+	if err := decoder.Decode(&content); err != nil {
+		content.Text = strings.Trim(n.Message, "\"")
+	}
+	if !content.IsRich() {
+		content.Text = strings.Trim(n.Message, "\"")
+	}
+	if content.Summary == "" && n.Subject != "" {
+		content.Summary = n.Subject
+	}
+	if content.IdempotencyKey == "" {
+		content.IdempotencyKey = n.MessageId
 	}
-	w.WriteHeader(http.StatusOK)
-	io.WriteString(w, http.StatusText(http.StatusOK))
 }
 
 type Content struct {
-	Channel       string             `json:"channel,omitempty"`
-	IconEmoji     string             `json:"icon_emoji,omitempty"`
-	IconURL       string             `json:"icon_url,omitempty"`
-	Username      string             `json:"username"`
-	Blocks        json.RawMessage    `json:"blocks,omitempty"`
-	Text          string             `json:"text,omitempty"`
-	EscapeText    bool               `json:"escape_text,omitempty"`
-	CodeBlockText bool               `json:"code_block_text,omitempty"`
-	Attachments   []slack.Attachment `json:"attachments,omitempty"`
-	AsFile        bool               `json:"as_file,omitempty"`
-	AsMessage     bool               `json:"as_message,omitempty"`
-	Filename      string             `json:"filename,omitempty"`
-	Summary       string             `json:"summary,omitempty"`
-	isJSON        *bool
+	Channel        string             `json:"channel,omitempty"`
+	IconEmoji      string             `json:"icon_emoji,omitempty"`
+	IconURL        string             `json:"icon_url,omitempty"`
+	Username       string             `json:"username"`
+	Blocks         json.RawMessage    `json:"blocks,omitempty"`
+	Text           string             `json:"text,omitempty"`
+	EscapeText     bool               `json:"escape_text,omitempty"`
+	CodeBlockText  bool               `json:"code_block_text,omitempty"`
+	Attachments    []slack.Attachment `json:"attachments,omitempty"`
+	AsFile         bool               `json:"as_file,omitempty"`
+	AsMessage      bool               `json:"as_message,omitempty"`
+	Filename       string             `json:"filename,omitempty"`
+	Summary        string             `json:"summary,omitempty"`
+	ThreadTS       string             `json:"thread_ts,omitempty"`
+	ReplyBroadcast bool               `json:"reply_broadcast,omitempty"`
+	ThreadKey      string             `json:"thread_key,omitempty"`
+	MessageKey     string             `json:"message_key,omitempty"`
+	TS             string             `json:"ts,omitempty"`
+	IdempotencyKey string             `json:"idempotency_key,omitempty"`
+	PostAt         *ScheduleTime      `json:"post_at,omitempty"`
+	Files          []ContentFile      `json:"files,omitempty"`
+	isJSON         *bool
+	payload        []byte
+	template       string
+	// unfurlLinks and mrkdwn are the options of the payloads of Slack Incoming Webhooks.
+	unfurlLinks *bool
+	mrkdwn      *bool
+}
+
+// UnmarshalJSON decodes the content, where the channel can be an array of channels to post to multiple channels.
+func (content *Content) UnmarshalJSON(bs []byte) error {
+	type alias Content
+	aux := struct {
+		*alias
+		Channel json.RawMessage `json:"channel,omitempty"`
+	}{
+		alias: (*alias)(content),
+	}
+	if err := json.Unmarshal(bs, &aux); err != nil {
+		return err
+	}
+	if len(aux.Channel) == 0 {
+		return nil
+	}
+	channel, err := unmarshalChannel(aux.Channel)
+	if err != nil {
+		return err
+	}
+	content.Channel = channel
+	return nil
+}
+
+// ContentFile is a file uploaded as is. Data is encoded as base64 in JSON.
+type ContentFile struct {
+	Filename string `json:"filename"`
+	Data     []byte `json:"data"`
 }
 
 func (content *Content) IsRich() bool {
@@ -434,6 +1017,30 @@ func (content *Content) Merge(c *Content) {
 	if c.Summary != "" {
 		content.Summary = c.Summary
 	}
+	if c.ThreadTS != "" {
+		content.ThreadTS = c.ThreadTS
+	}
+	if c.ReplyBroadcast {
+		content.ReplyBroadcast = c.ReplyBroadcast
+	}
+	if c.ThreadKey != "" {
+		content.ThreadKey = c.ThreadKey
+	}
+	if c.MessageKey != "" {
+		content.MessageKey = c.MessageKey
+	}
+	if c.TS != "" {
+		content.TS = c.TS
+	}
+	if c.IdempotencyKey != "" {
+		content.IdempotencyKey = c.IdempotencyKey
+	}
+	if c.PostAt != nil {
+		content.PostAt = c.PostAt
+	}
+	if len(c.Files) > 0 {
+		content.Files = c.Files
+	}
 }
 
 func (content *Content) IsJSON() bool {
@@ -459,11 +1066,6 @@ func (content *Content) IsJSON() bool {
 	return isJSON
 }
 
-var apiRetrier = &retrier{
-	timeout: 10 * time.Second,
-	jitter:  500 * time.Millisecond,
-}
-
 // see also https://api.slack.com/methods/chat.postMessage#:~:text=For%20best%20results%2C%20limit%20the,consider%20uploading%20a%20snippet%20instead.
 const uploadFilesThreshold = 4000
 const textMaxLength = 40000
@@ -494,9 +1096,112 @@ func (nwp *NowPaste) detectPostMode(content *Content) string {
 	return postAsMessage
 }
 
-func (nwp *NowPaste) postContent(ctx context.Context, content *Content) error {
-	if content.Channel == "" {
-		return errors.New("channel is required")
+// PostResult describes what was posted.
+type PostResult struct {
+	ChannelID string `json:"channel"`
+	TS        string `json:"ts,omitempty"`
+	FileID    string `json:"file_id,omitempty"`
+	Mode      string `json:"mode"`
+	Truncated bool   `json:"truncated"`
+	Permalink string `json:"permalink,omitempty"`
+	// Duplicate is true if the post is skipped for the duplicate idempotency key, and the result is of the original post.
+	Duplicate bool `json:"duplicate,omitempty"`
+	// ScheduledMessageID is the id of the scheduled message or job, if the content is scheduled by post_at.
+	ScheduledMessageID string `json:"scheduled_message_id,omitempty"`
+	PostAt             int64  `json:"post_at,omitempty"`
+	// Results are the results of each channel, if the content is posted to multiple channels.
+	Results []ChannelPostResult `json:"results,omitempty"`
+}
+
+func (nwp *NowPaste) postContent(ctx context.Context, content *Content) (*PostResult, error) {
+	channels := splitChannels(content.Channel)
+	if len(channels) == 0 {
+		return nil, ErrChannelRequired
+	}
+	if len(channels) > 1 {
+		return nwp.postFanOut(ctx, content, channels)
+	}
+	content.Channel = channels[0]
+	ticket := nwp.takeTurns(ctx, channels)[0]
+	defer ticket.release()
+	if err := ticket.wait(ctx); err != nil {
+		return nil, err
+	}
+	return nwp.postContentToChannel(ctx, content)
+}
+
+// postContentToChannel posts the content to the single channel of the content.
+func (nwp *NowPaste) postContentToChannel(ctx context.Context, content *Content) (*PostResult, error) {
+	if isDirectMessage(content.Channel) {
+		channelID, err := nwp.openDirectMessage(ctx, content.Channel)
+		if err != nil {
+			return nil, err
+		}
+		content.Channel = channelID
+	}
+	return nwp.postContentIdempotently(ctx, content)
+}
+
+// postAndRecord posts the content, with resolving and recording the thread key and the message key.
+func (nwp *NowPaste) postAndRecord(ctx context.Context, content *Content) (*PostResult, error) {
+	channel, threadKey := content.Channel, content.ThreadKey
+	var threadFound bool
+	if threadKey != "" && content.ThreadTS == "" {
+		// the first posts of a thread key are serialized, so that only one of them becomes the parent.
+		unlock := nwp.threadLocks.Lock(channel + "\x00" + threadKey)
+		threadTS, ok, err := nwp.threads.Get(ctx, channel, threadKey)
+		if err != nil {
+			log.Printf("[warn] get thread of key `%s`: %s", threadKey, err.Error())
+		}
+		if ok {
+			unlock()
+			log.Printf("[debug] thread key `%s` found, reply to %s", threadKey, threadTS)
+			content.ThreadTS = threadTS
+			threadFound = true
+		} else {
+			defer unlock()
+		}
+	}
+	result, err := nwp.post(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+	if result.ScheduledMessageID != "" {
+		return result, nil
+	}
+	if content.MessageKey != "" {
+		if result.TS == "" {
+			log.Printf("[warn] message key `%s` is only available for messages, not recorded for file %s", content.MessageKey, result.FileID)
+		} else if err := nwp.recordMessage(ctx, content.MessageKey, MessageRef{ChannelID: result.ChannelID, TS: result.TS}); err != nil {
+			log.Printf("[warn] %s", err.Error())
+		}
+	}
+	if threadKey != "" && !threadFound && content.ThreadTS == "" {
+		if result.TS == "" && result.FileID != "" {
+			result.TS, result.Permalink = nwp.fileShareTS(ctx, result.FileID, result.ChannelID)
+		}
+		if result.TS == "" {
+			log.Printf("[warn] timestamp of the post is unknown, thread key `%s` is not stored", threadKey)
+			return result, nil
+		}
+		if err := nwp.threads.Set(ctx, ThreadEntry{
+			Channel:   channel,
+			ThreadKey: threadKey,
+			ThreadTS:  result.TS,
+			TTL:       time.Now().Add(nwp.threadKeyTTL),
+		}); err != nil {
+			log.Printf("[warn] set thread of key `%s`: %s", threadKey, err.Error())
+		}
+	}
+	return result, nil
+}
+
+func (nwp *NowPaste) post(ctx context.Context, content *Content) (*PostResult, error) {
+	if content.PostAt != nil && content.PostAt.After(time.Now()) {
+		return nwp.schedule(ctx, content)
+	}
+	if len(content.Files) > 0 {
+		return nwp.postFiles(ctx, content)
 	}
 	switch nwp.detectPostMode(content) {
 	case postAsFile:
@@ -504,7 +1209,7 @@ func (nwp *NowPaste) postContent(ctx context.Context, content *Content) error {
 	case postAsMessage:
 		return nwp.postMessage(ctx, content)
 	default:
-		return errors.New("unknown post mode")
+		return nil, errors.New("unknown post mode")
 	}
 }
 
@@ -529,7 +1234,7 @@ func DetermineExtension(content []byte) string {
 	}
 }
 
-func (nwp *NowPaste) postFile(ctx context.Context, content *Content) error {
+func (nwp *NowPaste) postFile(ctx context.Context, content *Content) (*PostResult, error) {
 	log.Println("[debug] try post as file to ", content.Channel, "text size:", len(content.Text), "summary:", content.Summary)
 	if content.Filename == "" {
 		if content.IsJSON() {
@@ -538,6 +1243,64 @@ func (nwp *NowPaste) postFile(ctx context.Context, content *Content) error {
 			content.Filename = "message" + DetermineExtension([]byte(content.Text))
 		}
 	}
+	return nwp.uploadFile(ctx, content, content.Filename, []byte(content.Text), content.Summary)
+}
+
+// fileShareTS returns the timestamp of the message which shares the file in the channel, and the permalink of the file.
+// The file may not be shared yet just after the upload, so the timestamp can be empty.
+func (nwp *NowPaste) fileShareTS(ctx context.Context, fileID string, channelID string) (string, string) {
+	f, _, _, err := nwp.client.GetFileInfoContext(ctx, fileID, 0, 0)
+	if err != nil {
+		log.Printf("[debug] get file info of %s: %s", fileID, err.Error())
+		return "", ""
+	}
+	for _, shares := range []map[string][]slack.ShareFileInfo{f.Shares.Public, f.Shares.Private} {
+		if infos := shares[channelID]; len(infos) > 0 {
+			return infos[0].Ts, f.Permalink
+		}
+	}
+	return "", f.Permalink
+}
+
+// postFiles uploads the files of the content as is. The text (or the summary) is posted as the initial comment of the first file.
+func (nwp *NowPaste) postFiles(ctx context.Context, content *Content) (*PostResult, error) {
+	log.Println("[debug] try post files to ", content.Channel, "files:", len(content.Files))
+	comment := content.Summary
+	if content.Text != "" {
+		if comment != "" {
+			comment += "\n\n"
+		}
+		comment += content.Text
+	}
+	var result *PostResult
+	for i, file := range content.Files {
+		filename := file.Filename
+		if filename == "" {
+			filename = content.Filename
+		}
+		if filename == "" {
+			filename = "file" + DetermineExtension(file.Data)
+		}
+		if i > 0 {
+			comment = ""
+		}
+		r, err := nwp.uploadFile(ctx, content, filename, file.Data, comment)
+		if err != nil {
+			return nil, fmt.Errorf("file %s: %w", filename, err)
+		}
+		if result == nil {
+			result = r
+		}
+	}
+	return result, nil
+}
+
+// uploadFile uploads data to the channel of the content.
+// If the bot is not in the channel, it joins the channel, and if the channel is a name, the channel is resolved to the ID and content.Channel is updated.
+func (nwp *NowPaste) uploadFile(ctx context.Context, content *Content, filename string, data []byte, comment string) (*PostResult, error) {
+	if content.ThreadTS != "" && content.ReplyBroadcast {
+		log.Printf("[warn] reply_broadcast is not supported for files, upload %s only to the thread", filename)
+	}
 	if channelID, ok, err := nwp.cache.Get(ctx, content.Channel); err == nil && ok {
 		content.Channel = channelID
 	}
@@ -545,17 +1308,18 @@ func (nwp *NowPaste) postFile(ctx context.Context, content *Content) error {
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil, ctx.Err()
 		default:
 		}
-		err, timeout := apiRetrier.Do(ctx, func() error {
+		err, timeout := nwp.retrier.Do(ctx, func() error {
 			var err error
 			f, err = nwp.client.UploadFileV2Context(ctx, slack.UploadFileV2Parameters{
-				Channel:        content.Channel,
-				Reader:         strings.NewReader(content.Text),
-				Filename:       content.Filename,
-				FileSize:       len(content.Text),
-				InitialComment: content.Summary,
+				Channel:         content.Channel,
+				Reader:          bytes.NewReader(data),
+				Filename:        filename,
+				FileSize:        len(data),
+				InitialComment:  comment,
+				ThreadTimestamp: content.ThreadTS,
 			})
 			return err
 		})
@@ -563,41 +1327,45 @@ func (nwp *NowPaste) postFile(ctx context.Context, content *Content) error {
 			break
 		}
 		if timeout {
-			return err
+			return nil, err
 		}
 		var ser slack.SlackErrorResponse
 		if !errors.As(err, &ser) {
-			return fmt.Errorf("upload files: %w", err)
+			return nil, fmt.Errorf("upload files: %w", err)
 		}
 		switch ser.Err {
 		case "not_in_channel":
 			log.Printf("[warn] try upload files but not in channel, try join channel to %s", content.Channel)
-			err, _ = apiRetrier.Do(ctx, func() error {
+			err, _ = nwp.retrier.Do(ctx, func() error {
 				_, _, _, err := nwp.client.JoinConversationContext(ctx, content.Channel)
 				return err
 			})
 			if err != nil {
 				log.Printf("[debug] join channel: %#v", err)
-				return fmt.Errorf("join channel may be not channel id: %w", err)
+				return nil, fmt.Errorf("join channel may be not channel id: %w", err)
 			}
 		case "channel_not_found":
 			log.Printf("[warn] try upload files but channel not found, try search channel to %s", content.Channel)
 			channelID, ok, err := nwp.searchChannel(ctx, content.Channel)
 			if err != nil {
-				return fmt.Errorf("search channel: %w", err)
+				return nil, fmt.Errorf("search channel: %w", err)
 			}
 			if !ok {
-				return fmt.Errorf("channel not found: %s", content.Channel)
+				return nil, fmt.Errorf("%w: %s", ErrChannelNotFound, content.Channel)
 			}
 			content.Channel = channelID
 		default:
 			log.Printf("[debug] try upload files, slack error response: %s", ser.Error())
-			return fmt.Errorf("upload files: %w", ser)
+			return nil, fmt.Errorf("upload files: %w", ser)
 		}
 		log.Println("[debug] retry upload files")
 	}
 	log.Printf("[info] upload File to %s, file id is `%s`", content.Channel, f.ID)
-	return nil
+	return &PostResult{
+		ChannelID: content.Channel,
+		FileID:    f.ID,
+		Mode:      postAsFile,
+	}, nil
 }
 
 func (nwp *NowPaste) searchChannel(ctx context.Context, channelName string) (channelID string, ok bool, err error) {
@@ -615,7 +1383,7 @@ func (nwp *NowPaste) searchChannel(ctx context.Context, channelName string) (cha
 		}
 		var channels []slack.Channel
 		var nextCursor string
-		err, _ = apiRetrier.Do(ctx, func() error {
+		err, _ = nwp.retrier.Do(ctx, func() error {
 			var err error
 			channels, nextCursor, err = nwp.client.GetConversationsContext(ctx, &slack.GetConversationsParameters{
 				Limit:  1000,
@@ -649,27 +1417,24 @@ func (nwp *NowPaste) searchChannel(ctx context.Context, channelName string) (cha
 	return "", false, nil
 }
 
-func (nwp *NowPaste) postMessage(ctx context.Context, content *Content) error {
-	log.Println("[debug] try post as message to ", content.Channel, "text size:", len(content.Text))
+// messageOptions returns the options of the message body (blocks, attachments and text), which are common to posting and updating.
+// The text is formatted with the summary, truncation and code block, and it reports whether the text is truncated.
+func messageOptions(content *Content) ([]slack.MsgOption, bool, error) {
 	opts := make([]slack.MsgOption, 0)
-	if content.IconEmoji != "" {
-		opts = append(opts, slack.MsgOptionIconEmoji(content.IconEmoji))
-	} else if content.IconURL != "" {
-		opts = append(opts, slack.MsgOptionIconURL(content.IconURL))
-	}
-	if content.Username != "" {
-		opts = append(opts, slack.MsgOptionUsername(content.Username))
-	}
 	if len(content.Blocks) > 0 {
 		var blocks slack.Blocks
 		if err := json.Unmarshal(content.Blocks, &blocks); err != nil {
-			return err
+			return nil, false, err
 		}
 		opts = append(opts, slack.MsgOptionBlocks(blocks.BlockSet...))
 	}
 	if len(content.Attachments) > 0 {
 		opts = append(opts, slack.MsgOptionAttachments(content.Attachments...))
 	}
+	if content.mrkdwn != nil && !*content.mrkdwn {
+		opts = append(opts, slack.MsgOptionDisableMarkdown())
+	}
+	var truncated bool
 	if content.Text != "" {
 		if content.Summary != "" {
 			content.Text = content.Summary + "\n\n" + content.Text
@@ -677,25 +1442,72 @@ func (nwp *NowPaste) postMessage(ctx context.Context, content *Content) error {
 		if len(content.Text) > textMaxLength {
 			content.Text = content.Text[:textMaxLength-100]
 			content.Text += "\n...(truncated)"
+			truncated = true
 		}
 		if content.CodeBlockText {
 			content.Text = "```" + content.Text + "```"
 		}
 		opts = append(opts, slack.MsgOptionText(content.Text, content.EscapeText))
 	}
+	return opts, truncated, nil
+}
+
+// postMessageOptions returns the options to post the content as a message, which are common to posting and scheduling.
+func postMessageOptions(content *Content) ([]slack.MsgOption, bool, error) {
+	opts := make([]slack.MsgOption, 0)
+	if content.IconEmoji != "" {
+		opts = append(opts, slack.MsgOptionIconEmoji(content.IconEmoji))
+	} else if content.IconURL != "" {
+		opts = append(opts, slack.MsgOptionIconURL(content.IconURL))
+	}
+	if content.Username != "" {
+		opts = append(opts, slack.MsgOptionUsername(content.Username))
+	}
+	if content.ThreadTS != "" {
+		opts = append(opts, slack.MsgOptionTS(content.ThreadTS))
+		if content.ReplyBroadcast {
+			opts = append(opts, slack.MsgOptionBroadcast())
+		}
+	}
+	if content.unfurlLinks != nil {
+		if *content.unfurlLinks {
+			opts = append(opts, slack.MsgOptionEnableLinkUnfurl())
+		} else {
+			opts = append(opts, slack.MsgOptionDisableLinkUnfurl())
+		}
+	}
+	bodyOpts, truncated, err := messageOptions(content)
+	if err != nil {
+		return nil, false, err
+	}
+	return append(opts, bodyOpts...), truncated, nil
+}
+
+func (nwp *NowPaste) postMessage(ctx context.Context, content *Content) (*PostResult, error) {
+	log.Println("[debug] try post as message to ", content.Channel, "text size:", len(content.Text))
+	opts, truncated, err := postMessageOptions(content)
+	if err != nil {
+		return nil, err
+	}
 	log.Printf("[debug] try post message to %s", content.Channel)
 	var postedChannelID, postedTimestamp string
-	err, _ := apiRetrier.Do(ctx, func() error {
+	err, _ = nwp.retrier.Do(ctx, func() error {
 		var err error
 		postedChannelID, postedTimestamp, err = nwp.client.PostMessageContext(ctx, content.Channel, opts...)
 		return err
 	})
 	if err != nil {
-		return fmt.Errorf("post message: %w", err)
+		return nil, fmt.Errorf("post message: %w", err)
+	}
+	result := &PostResult{
+		ChannelID: postedChannelID,
+		TS:        postedTimestamp,
+		Mode:      postAsMessage,
+		Truncated: truncated,
 	}
 	if postedChannelID == content.Channel {
 		log.Printf("[info] post Message to %s at %s", postedChannelID, postedTimestamp)
-		return nil
+		return result, nil
 	}
 	log.Printf("[info] post Message to %s(%s) at %s", content.Channel, postedChannelID, postedTimestamp)
 	if err := nwp.cache.SetMulti(ctx, []ChannelCacheEntry{
@@ -703,26 +1515,75 @@ func (nwp *NowPaste) postMessage(ctx context.Context, content *Content) error {
 	}); err != nil {
 		log.Printf("[warn] cache set failed: %s", err.Error())
 	}
-	return nil
+	return result, nil
 }
 
+const (
+	// DefaultRetryMaxAttempts is the default max number of attempts of a Slack API call, including the first attempt.
+	DefaultRetryMaxAttempts = 5
+	// DefaultRetryBudget is the default total duration to retry a Slack API call.
+	DefaultRetryBudget = 10 * time.Second
+	// DefaultRetryMaxRateLimitWait is the default max total duration to wait for Retry-After of a Slack API call, which is out of the budget.
+	DefaultRetryMaxRateLimitWait = time.Minute
+)
+
+const (
+	retryBaseDelay       = 200 * time.Millisecond
+	retryMaxDelay        = 3 * time.Second
+	retryRateLimitJitter = 500 * time.Millisecond
+)
+
+// retrier retries Slack API calls failed transiently.
+// Rate limits are retried after Retry-After, and the other transient errors (Slack server errors and network errors)
+// are retried with exponential backoff and full jitter. Permanent errors are not retried.
 type retrier struct {
-	timeout time.Duration
-	jitter  time.Duration
-	mu      sync.Mutex
-	rand    *rand.Rand
+	maxAttempts      int
+	budget           time.Duration
+	maxRateLimitWait time.Duration
+	baseDelay        time.Duration
+	maxDelay         time.Duration
+	jitter           time.Duration
+	mu               sync.Mutex
+	rand             *rand.Rand
 }
 
+func newRetrier(maxAttempts int, budget time.Duration) *retrier {
+	return &retrier{
+		maxAttempts:      maxAttempts,
+		budget:           budget,
+		maxRateLimitWait: DefaultRetryMaxRateLimitWait,
+		baseDelay:        retryBaseDelay,
+		maxDelay:         retryMaxDelay,
+		jitter:           retryRateLimitJitter,
+	}
+}
+
+// Do calls f until it succeeds, fails permanently, or the attempts or the budget are exhausted.
+// The waits for Retry-After are not limited by the budget but by the max rate limit wait, as Slack tells when to retry.
+// It returns the last error, and whether the retries are exhausted.
 func (r *retrier) Do(ctx context.Context, f func() error) (error, bool) {
 	start := time.Now()
 	err := f()
 	var t *time.Timer
-	var rle *slack.RateLimitedError
-	for err != nil && errors.As(err, &rle) && rle.Retryable() {
-		if time.Since(start) >= r.timeout {
+	var rateLimitWait time.Duration
+	for attempt := 1; err != nil && isTransientError(err); attempt++ {
+		if ctx.Err() != nil {
+			return err, true
+		}
+		if attempt >= r.maxAttempts {
 			return err, true
 		}
-		delay := rle.RetryAfter + r.randomJitter()
+		delay := r.delay(attempt, err)
+		var rle *slack.RateLimitedError
+		if errors.As(err, &rle) {
+			if rateLimitWait+delay > r.maxRateLimitWait {
+				return err, true
+			}
+			rateLimitWait += delay
+		} else if time.Since(start)-rateLimitWait+delay > r.budget {
+			return err, true
+		}
+		log.Printf("[debug] retry after %s (attempt %d of %d): %s", delay, attempt, r.maxAttempts, err.Error())
 		if t == nil {
 			t = time.NewTimer(delay)
 			defer t.Stop()
@@ -736,10 +1597,26 @@ func (r *retrier) Do(ctx context.Context, f func() error) (error, bool) {
 		}
 		err = f()
 	}
-	return err, time.Since(start) >= r.timeout
+	return err, false
 }
 
-func (r *retrier) randomJitter() time.Duration {
+// delay returns the delay before the next attempt, Retry-After for rate limits, and full jitter of exponential backoff for the others.
+func (r *retrier) delay(attempt int, err error) time.Duration {
+	var rle *slack.RateLimitedError
+	if errors.As(err, &rle) {
+		return rle.RetryAfter + r.randomDuration(r.jitter)
+	}
+	backoff := r.maxDelay
+	if attempt < 32 {
+		backoff = min(r.baseDelay<<(attempt-1), r.maxDelay)
+	}
+	return r.randomDuration(backoff)
+}
+
+func (r *retrier) randomDuration(n time.Duration) time.Duration {
+	if n <= 0 {
+		return 0
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -750,5 +1627,5 @@ func (r *retrier) randomJitter() time.Duration {
 		}
 		r.rand = rand.New(rand.NewSource(seed))
 	}
-	return time.Duration(r.rand.Int63n(int64(r.jitter)))
+	return time.Duration(r.rand.Int63n(int64(n)))
 }
@@ -4,14 +4,19 @@ import (
 	"bytes"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/sebdah/goldie/v2"
 	"github.com/slack-go/slack"
@@ -37,6 +42,7 @@ func (w *statusCodeRecoder) WriteHeader(statusCode int) {
 
 type postRootTestCase struct {
 	name           string
+	path           string
 	requestHeaders map[string]string
 	newRequestBody func(t *testing.T) io.Reader
 	expectedStatus int
@@ -54,11 +60,32 @@ var filesGetUploadURLExtendedResponse string
 //go:embed testdata/example_files_complete_upload_external_response.json
 var filesCompleteUploadExternalResponse string
 
-func (c postRootTestCase) Run(t *testing.T, g *goldie.Goldie, middlewares ...func(func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request)) {
+type middleware func(func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request)
+
+func (c postRootTestCase) Run(t *testing.T, g *goldie.Goldie, middlewares ...middleware) {
 	var apiCallLog bytes.Buffer
 	defer func() {
 		g.Assert(t, c.name, apiCallLog.Bytes())
 	}()
+	client := newMockedNowPaste(t, &apiCallLog, middlewares...)
+
+	path := c.path
+	if path == "" {
+		path = "/"
+	}
+	req := httptest.NewRequest(http.MethodPost, path, c.newRequestBody(t))
+	for key, value := range c.requestHeaders {
+		req.Header.Add(key, value)
+	}
+	w := httptest.NewRecorder()
+	client.ServeHTTP(w, req)
+	resp := w.Result()
+	if resp.StatusCode != c.expectedStatus {
+		t.Error("http status unexpected ", resp)
+	}
+}
+
+func newMockedSlackAPI() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/auth.test", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -79,6 +106,13 @@ func (c postRootTestCase) Run(t *testing.T, g *goldie.Goldie, middlewares ...fun
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, filesCompleteUploadExternalResponse)
 	})
+	return mux
+}
+
+// newMockedNowPaste returns NowPaste with a mocked slack api, which dumps api calls to apiCallLog.
+func newMockedNowPaste(t *testing.T, apiCallLog io.Writer, middlewares ...middleware) *NowPaste {
+	t.Helper()
+	mux := newMockedSlackAPI()
 	var f http.HandlerFunc
 	f = mux.ServeHTTP
 	for _, m := range middlewares {
@@ -101,23 +135,13 @@ func (c postRootTestCase) Run(t *testing.T, g *goldie.Goldie, middlewares ...fun
 					}
 				}
 			}
-			fmt.Fprintf(&apiCallLog, "--- request --\n%s\n", dump)
+			fmt.Fprintf(apiCallLog, "--- request --\n%s\n", dump)
 			recoder := &statusCodeRecoder{ResponseWriter: w}
 			f(recoder, r)
-			fmt.Fprintf(&apiCallLog, "--- response status ---\n%d %s\n=====================\n", recoder.staus, http.StatusText(recoder.staus))
+			fmt.Fprintf(apiCallLog, "--- response status ---\n%d %s\n=====================\n", recoder.staus, http.StatusText(recoder.staus))
 		}),
 	)))
-
-	req := httptest.NewRequest(http.MethodPost, "/", c.newRequestBody(t))
-	for key, value := range c.requestHeaders {
-		req.Header.Add(key, value)
-	}
-	w := httptest.NewRecorder()
-	client.ServeHTTP(w, req)
-	resp := w.Result()
-	if resp.StatusCode != c.expectedStatus {
-		t.Error("http status unexpected ", resp)
-	}
+	return client
 }
 
 var postRootTestCases []postRootTestCase = []postRootTestCase{
@@ -149,8 +173,55 @@ var postRootTestCases []postRootTestCase = []postRootTestCase{
 		},
 		expectedStatus: http.StatusOK,
 	},
+	{
+		name: "multipart_files",
+		path: "/?channel=C0123456789",
+		requestHeaders: map[string]string{
+			"Content-Type": "multipart/form-data; boundary=" + testMultipartBoundary,
+		},
+		newRequestBody: func(t *testing.T) io.Reader {
+			var buf bytes.Buffer
+			mw := multipart.NewWriter(&buf)
+			if err := mw.SetBoundary(testMultipartBoundary); err != nil {
+				t.Fatal(err)
+			}
+			if err := mw.WriteField("summary", "build artifacts"); err != nil {
+				t.Fatal(err)
+			}
+			for _, file := range []ContentFile{
+				{Filename: "image.png", Data: []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0x00, 0xff}},
+				{Filename: "archive.zip", Data: []byte{'P', 'K', 0x03, 0x04, 0x00, 0xff}},
+			} {
+				fw, err := mw.CreateFormFile("file", file.Filename)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := fw.Write(file.Data); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := mw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			return &buf
+		},
+		expectedStatus: http.StatusOK,
+	},
+	{
+		name: "binary",
+		path: "/?channel=C0123456789&filename=image.png",
+		requestHeaders: map[string]string{
+			"Content-Type": "application/octet-stream",
+		},
+		newRequestBody: func(t *testing.T) io.Reader {
+			return bytes.NewReader([]byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0x00, 0xff})
+		},
+		expectedStatus: http.StatusOK,
+	},
 }
 
+const testMultipartBoundary = "nowpaste-test-boundary"
+
 func TestPostRootSuccess(t *testing.T) {
 	g := goldie.New(t,
 		goldie.WithFixtureDir("testdata/post_root_success/"),
@@ -184,3 +255,206 @@ func TestPostRootRetryOnce(t *testing.T) {
 		})
 	}
 }
+
+func TestPostThreadReply(t *testing.T) {
+	cases := []struct {
+		name          string
+		path          string
+		contentType   string
+		body          string
+		expectedCalls []string
+	}{
+		{
+			name:          "json",
+			path:          "/",
+			contentType:   "application/json",
+			body:          `{"channel":"C0123456789","text":"step 1 done","thread_ts":"1503435956.000247","reply_broadcast":true}`,
+			expectedCalls: []string{"chat.postMessage", "thread_ts=1503435956.000247", "reply_broadcast=true"},
+		},
+		{
+			name:          "query",
+			path:          "/?channel=C0123456789&thread_ts=1503435956.000247",
+			contentType:   "text/plain",
+			body:          "step 2 done",
+			expectedCalls: []string{"chat.postMessage", "thread_ts=1503435956.000247"},
+		},
+		{
+			name:          "form",
+			path:          "/",
+			contentType:   "application/x-www-form-urlencoded",
+			body:          "channel=C0123456789&text=step+3+done&thread_ts=1503435956.000247&reply_broadcast=true",
+			expectedCalls: []string{"chat.postMessage", "thread_ts=1503435956.000247", "reply_broadcast=true"},
+		},
+		{
+			name:          "file",
+			path:          "/?channel=C0123456789&thread_ts=1503435956.000247&as_file=true",
+			contentType:   "text/plain",
+			body:          "step 4 output",
+			expectedCalls: []string{"files.completeUploadExternal", "thread_ts=1503435956.000247"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var apiCallLog bytes.Buffer
+			nwp := newMockedNowPaste(t, &apiCallLog)
+			req := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body))
+			req.Header.Set("Content-Type", c.contentType)
+			w := httptest.NewRecorder()
+			nwp.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Errorf("unexpected status: %d", w.Code)
+			}
+			for _, expected := range c.expectedCalls {
+				if !strings.Contains(apiCallLog.String(), expected) {
+					t.Errorf("api calls do not contain `%s`: %s", expected, apiCallLog.String())
+				}
+			}
+		})
+	}
+}
+
+func TestApplySNSMessageAttributesThread(t *testing.T) {
+	content := &Content{}
+	applySNSMessageAttributes(content, map[string]MessageAttribute{
+		"thread_ts":       {Type: "String", Value: "1503435956.000247"},
+		"reply_broadcast": {Type: "String", Value: "true"},
+	})
+	if content.ThreadTS != "1503435956.000247" || !content.ReplyBroadcast {
+		t.Errorf("unexpected content: %#v", content)
+	}
+}
+
+func TestPostJSONResponse(t *testing.T) {
+	permalinkAPI := func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/chat.getPermalink":
+				fmt.Fprintf(w, `{"ok":true,"channel":"%s","permalink":"https://example.slack.com/archives/%s/p1503435956000247"}`, r.FormValue("channel"), r.FormValue("channel"))
+			case "/api/files.info":
+				fmt.Fprint(w, `{"ok":true,"file":{"id":"F123ABC456","permalink":"https://example.slack.com/files/U123/F123ABC456/message.txt","shares":{"public":{"C0123456789":[{"ts":"1503435957.000100"}]}}}}`)
+			default:
+				next(w, r)
+			}
+		}
+	}
+	cases := []struct {
+		name     string
+		path     string
+		body     string
+		accept   string
+		expected string
+	}{
+		{
+			name:     "message",
+			path:     "/?channel=C0123456789",
+			body:     "hello",
+			accept:   "application/json",
+			expected: `{"channel":"C123456","ts":"1503435956.000247","mode":"message","truncated":false,"permalink":"https://example.slack.com/archives/C123456/p1503435956000247"}`,
+		},
+		{
+			name:     "file",
+			path:     "/?channel=C0123456789&as_file=true",
+			body:     "hello",
+			accept:   "text/html, application/json;q=0.9",
+			expected: `{"channel":"C0123456789","ts":"1503435957.000100","file_id":"F123ABC456","mode":"file","truncated":false,"permalink":"https://example.slack.com/files/U123/F123ABC456/message.txt"}`,
+		},
+		{
+			name:     "truncated",
+			path:     "/?channel=C0123456789&as_message=true",
+			body:     strings.Repeat("a", 50000),
+			accept:   "application/json",
+			expected: `{"channel":"C123456","ts":"1503435956.000247","mode":"message","truncated":true,"permalink":"https://example.slack.com/archives/C123456/p1503435956000247"}`,
+		},
+		{
+			name:     "plain",
+			path:     "/?channel=C0123456789",
+			body:     "hello",
+			expected: `OK`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			nwp := newMockedNowPaste(t, io.Discard, permalinkAPI)
+			req := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body))
+			req.Header.Set("Content-Type", "text/plain")
+			if c.accept != "" {
+				req.Header.Set("Accept", c.accept)
+			}
+			w := httptest.NewRecorder()
+			nwp.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Errorf("unexpected status: %d", w.Code)
+			}
+			if body := strings.TrimSpace(w.Body.String()); body != c.expected {
+				t.Errorf("unexpected body:\n got: %s\nwant: %s", body, c.expected)
+			}
+		})
+	}
+}
+
+func TestRetrierDo(t *testing.T) {
+	cases := []struct {
+		name              string
+		errs              []error
+		budget            time.Duration
+		expectedAttempts  int
+		expectedExhausted bool
+	}{
+		{name: "success", errs: []error{nil}, budget: time.Second, expectedAttempts: 1},
+		{name: "permanent", errs: []error{slack.SlackErrorResponse{Err: "channel_not_found"}}, budget: time.Second, expectedAttempts: 1},
+		{name: "unknown", errs: []error{errors.New("unknown")}, budget: time.Second, expectedAttempts: 1},
+		{
+			name:             "transient",
+			errs:             []error{slack.SlackErrorResponse{Err: "internal_error"}, slack.StatusCodeError{Code: 502, Status: "502 Bad Gateway"}, &net.OpError{Op: "read", Err: syscall.ECONNRESET}, nil},
+			budget:           time.Second,
+			expectedAttempts: 4,
+		},
+		{
+			name:              "max_attempts",
+			errs:              []error{slack.SlackErrorResponse{Err: "service_unavailable"}},
+			budget:            time.Second,
+			expectedAttempts:  5,
+			expectedExhausted: true,
+		},
+		{
+			name:              "budget",
+			errs:              []error{slack.SlackErrorResponse{Err: "internal_error"}},
+			budget:            0,
+			expectedAttempts:  1,
+			expectedExhausted: true,
+		},
+		{
+			name:             "rate_limit_out_of_budget",
+			errs:             []error{&slack.RateLimitedError{RetryAfter: 50 * time.Millisecond}, nil},
+			budget:           10 * time.Millisecond,
+			expectedAttempts: 2,
+		},
+		{
+			name:              "max_rate_limit_wait",
+			errs:              []error{&slack.RateLimitedError{RetryAfter: 60 * time.Millisecond}},
+			budget:            time.Second,
+			expectedAttempts:  2,
+			expectedExhausted: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := newRetrier(5, c.budget)
+			r.baseDelay = time.Millisecond
+			r.jitter = 0
+			r.maxRateLimitWait = 100 * time.Millisecond
+			var attempts int
+			err, exhausted := r.Do(t.Context(), func() error {
+				err := c.errs[min(attempts, len(c.errs)-1)]
+				attempts++
+				return err
+			})
+			if attempts != c.expectedAttempts || exhausted != c.expectedExhausted {
+				t.Errorf("expected %d attempts (exhausted=%v), got %d attempts (exhausted=%v): %v", c.expectedAttempts, c.expectedExhausted, attempts, exhausted, err)
+			}
+			if expected := c.errs[len(c.errs)-1]; fmt.Sprint(err) != fmt.Sprint(expected) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
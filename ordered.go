@@ -0,0 +1,180 @@
+package nowpaste
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+)
+
+// orderedQueue delivers the posts of the same channel in FIFO order, while the posts of different channels are delivered in parallel.
+// A post takes its turn of the channel on arrival, and waits for the posts which took the turns before it.
+type orderedQueue struct {
+	mu    sync.Mutex
+	tails map[string]*orderedTicket
+	// keys are the resolved keys of the channel spellings, which never change once resolved.
+	keys map[string]string
+}
+
+// orderedTicket is a turn of a post in the channel. The nil ticket does not wait.
+type orderedTicket struct {
+	q    *orderedQueue
+	key  string
+	prev chan struct{}
+	done chan struct{}
+}
+
+func newOrderedQueue() *orderedQueue {
+	return &orderedQueue{
+		tails: make(map[string]*orderedTicket),
+		keys:  make(map[string]string),
+	}
+}
+
+// enqueue takes the turns of the keys at once, so that the posts to multiple channels keep the same order in every channel.
+func (q *orderedQueue) enqueue(keys ...string) []*orderedTicket {
+	tickets := make([]*orderedTicket, len(keys))
+	if q == nil {
+		return tickets
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, key := range keys {
+		t := &orderedTicket{q: q, key: key, done: make(chan struct{})}
+		if tail, ok := q.tails[key]; ok {
+			t.prev = tail.done
+		}
+		q.tails[key] = t
+		tickets[i] = t
+	}
+	return tickets
+}
+
+// wait waits for the turn of the ticket.
+func (t *orderedTicket) wait(ctx context.Context) error {
+	if t == nil || t.prev == nil {
+		return nil
+	}
+	select {
+	case <-t.prev:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release passes the turn to the next post. If the ticket is released before its turn, e.g. canceled,
+// the turn is passed after the previous posts are done.
+func (t *orderedTicket) release() {
+	if t == nil {
+		return
+	}
+	if t.prev != nil {
+		select {
+		case <-t.prev:
+		default:
+			go func() {
+				<-t.prev
+				t.finish()
+			}()
+			return
+		}
+	}
+	t.finish()
+}
+
+func (t *orderedTicket) finish() {
+	t.q.mu.Lock()
+	defer t.q.mu.Unlock()
+	close(t.done)
+	if t.q.tails[t.key] == t {
+		delete(t.q.tails, t.key)
+	}
+}
+
+// keyOf returns the resolved key of the channel spelling.
+func (q *orderedQueue) keyOf(channel string) (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key, ok := q.keys[channel]
+	return key, ok
+}
+
+// setKey sets the key of the channel spelling, unless it has been set concurrently, and returns the key of the spelling.
+func (q *orderedQueue) setKey(channel string, key string) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if k, ok := q.keys[channel]; ok {
+		return k
+	}
+	q.keys[channel] = key
+	return key
+}
+
+// orderedKeys returns the keys of the channels in the ordered delivery, so that the spellings of a channel share the order.
+func (nwp *NowPaste) orderedKeys(ctx context.Context, channels []string) []string {
+	keys := make([]string, len(channels))
+	for i, channel := range channels {
+		keys[i] = nwp.orderedKey(ctx, channel)
+	}
+	return keys
+}
+
+// orderedKey resolves the channel to its channel ID before the post takes its turn, so that `#ops`, `ops` and its ID share the order.
+// The key of a spelling is kept once resolved, so it does not change with the channel cache.
+// A channel which is not found, e.g. a private channel out of the search channel types, is keyed by its name.
+func (nwp *NowPaste) orderedKey(ctx context.Context, channel string) string {
+	name := channel
+	if !isDirectMessage(channel) {
+		name = strings.TrimPrefix(channel, "#")
+	}
+	if isChannelID(name) {
+		return name
+	}
+	if key, ok := nwp.ordered.keyOf(name); ok {
+		return key
+	}
+	if isDirectMessage(name) {
+		channelID, err := nwp.openDirectMessage(ctx, name)
+		if err != nil {
+			// the post fails in the same way, so the key is resolved again by the next post.
+			log.Printf("[warn] resolve the ordered key of %s: %s", name, err.Error())
+			return name
+		}
+		return nwp.ordered.setKey(name, channelID)
+	}
+	// a post to `#ops` caches the channel ID by the spelling.
+	if channelID, ok, err := nwp.cache.Get(ctx, "#"+name); err == nil && ok {
+		return nwp.ordered.setKey(name, channelID)
+	}
+	channelID, ok, err := nwp.searchChannel(ctx, name)
+	if err != nil {
+		log.Printf("[warn] resolve the ordered key of %s: %s", name, err.Error())
+		return name
+	}
+	if !ok {
+		return nwp.ordered.setKey(name, name)
+	}
+	return nwp.ordered.setKey(name, channelID)
+}
+
+// isChannelID reports whether the channel is a channel ID, e.g. `C0123456789`. The channel names are lowercase.
+func isChannelID(channel string) bool {
+	if len(channel) < 9 || !strings.ContainsRune("CGD", rune(channel[0])) {
+		return false
+	}
+	for _, r := range channel[1:] {
+		if !('A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// takeTurns takes the turns of the channels in the ordered delivery. The tickets are nil if the ordered delivery is disabled.
+func (nwp *NowPaste) takeTurns(ctx context.Context, channels []string) []*orderedTicket {
+	if nwp.ordered == nil {
+		return make([]*orderedTicket, len(channels))
+	}
+	return nwp.ordered.enqueue(nwp.orderedKeys(ctx, channels)...)
+}
@@ -0,0 +1,217 @@
+package nowpaste
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockPostMessage records the posted texts by channel, and blocks posting the text until the release is closed.
+func blockPostMessage(text string, entered chan<- struct{}, release <-chan struct{}, mu *sync.Mutex, posted map[string][]string) middleware {
+	return func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/chat.postMessage" {
+				next(w, r)
+				return
+			}
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if r.PostForm.Get("text") == text {
+				entered <- struct{}{}
+				<-release
+			}
+			mu.Lock()
+			posted[r.PostForm.Get("channel")] = append(posted[r.PostForm.Get("channel")], r.PostForm.Get("text"))
+			mu.Unlock()
+			next(w, r)
+		}
+	}
+}
+
+func TestOrderedQueue(t *testing.T) {
+	q := newOrderedQueue()
+	first := q.enqueue("C1", "C2")
+	second := q.enqueue("C1")[0]
+	third := q.enqueue("C1")[0]
+	other := q.enqueue("C3")[0]
+	if err := other.wait(t.Context()); err != nil {
+		t.Errorf("expected the other channel not to wait: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	if err := second.wait(ctx); err == nil {
+		t.Error("expected to wait for the first post")
+	}
+	// the canceled post passes the turn after the first post is done.
+	second.release()
+	ctx, cancel = context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	if err := third.wait(ctx); err == nil {
+		t.Error("expected to wait for the first post")
+	}
+	for _, ticket := range first {
+		ticket.release()
+	}
+	if err := third.wait(t.Context()); err != nil {
+		t.Error(err)
+	}
+	third.release()
+	other.release()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.tails) != 0 {
+		t.Errorf("expected all turns to be done, got %d", len(q.tails))
+	}
+
+	var disabled *orderedQueue
+	if tickets := disabled.enqueue("C1"); tickets[0].wait(t.Context()) != nil {
+		t.Error("expected not to wait when disabled")
+	}
+}
+
+func TestPostContentOrdered(t *testing.T) {
+	var mu sync.Mutex
+	posted := make(map[string][]string)
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	nwp := newMockedNowPaste(t, io.Discard, blockPostMessage("first", entered, release, &mu, posted))
+	nwp.SetOrderedDelivery(true)
+
+	var wg sync.WaitGroup
+	post := func(content *Content) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := nwp.postContent(t.Context(), content); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	post(&Content{Channel: "C0000000001", Text: "first"})
+	<-entered
+	waitPosted := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for {
+			mu.Lock()
+			got := len(posted["C0000000002"])
+			mu.Unlock()
+			if got >= n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d posts to the other channel, got %d", n, got)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	post(&Content{Channel: "C0000000001,C0000000002", Text: "second"})
+	waitPosted(1)
+	post(&Content{Channel: "C0000000002", Text: "third"})
+	waitPosted(2)
+	mu.Lock()
+	if got := posted["C0000000001"]; len(got) != 0 {
+		t.Errorf("expected to wait for the first post, got %v", got)
+	}
+	if got := posted["C0000000002"]; len(got) != 2 || got[0] != "second" || got[1] != "third" {
+		t.Errorf("expected the other channel to be posted in order, got %v", got)
+	}
+	mu.Unlock()
+	close(release)
+	wg.Wait()
+	if got := posted["C0000000001"]; len(got) != 2 || got[0] != "first" || got[1] != "second" {
+		t.Errorf("unexpected order: %v", got)
+	}
+}
+
+func TestDispatchQueueJobsOrdered(t *testing.T) {
+	nwp := newMockedNowPaste(t, io.Discard)
+	nwp.SetQueueStore(NewInmemoryQueueStore())
+	nwp.SetOrderedDelivery(true)
+	now := time.Now()
+	jobs := []*QueueJob{
+		{ID: "retrying", Content: &Content{Channel: "C0000000001", Text: "1"}, NextAttemptAt: now.Add(time.Minute)},
+		{ID: "waiting", Content: &Content{Channel: "C0000000001", Text: "2"}},
+		{ID: "fanout", Content: &Content{Channel: "C0000000002,C0000000001", Text: "3"}},
+		{ID: "ready", Content: &Content{Channel: "C0000000002", Text: "4"}},
+		{ID: "other", Content: &Content{Channel: "C0000000003", Text: "5"}},
+	}
+	for i, job := range jobs {
+		job.Status = QueueJobStatusQueued
+		job.CreatedAt = now.Add(time.Duration(i) * time.Second)
+		if err := nwp.queue.Put(t.Context(), job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := &queueDispatcher{
+		jobs:     make(chan *QueueJob, len(jobs)),
+		inflight: make(map[string]bool),
+	}
+	if n := nwp.dispatchQueueJobs(t.Context(), d, now); n != 1 {
+		t.Errorf("expected 1 job to be handed, got %d", n)
+	}
+	close(d.jobs)
+	for job := range d.jobs {
+		if job.ID != "other" {
+			t.Errorf("unexpected job handed: %s", job.ID)
+		}
+	}
+}
+
+func TestOrderedKey(t *testing.T) {
+	var searched int
+	conversationsList := func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/conversations.list" {
+				next(w, r)
+				return
+			}
+			searched++
+			io.WriteString(w, `{"ok":true,"channels":[{"id":"C0000000003","name":"dev"}],"response_metadata":{"next_cursor":""}}`)
+		}
+	}
+	nwp := newMockedNowPaste(t, io.Discard, conversationsList)
+	nwp.SetOrderedDelivery(true)
+	ttl := time.Now().Add(time.Hour)
+	if err := nwp.cache.SetMulti(t.Context(), []ChannelCacheEntry{
+		{ChannelName: "#ops", ChannelID: "C0000000001", TTL: ttl},
+		{ChannelName: "@alice", ChannelID: "D0000000001", TTL: ttl},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		channel  string
+		expected string
+	}{
+		{channel: "ops", expected: "C0000000001"},
+		{channel: "#ops", expected: "C0000000001"},
+		{channel: "C0000000001", expected: "C0000000001"},
+		{channel: "@alice", expected: "D0000000001"},
+		{channel: "#dev", expected: "C0000000003"},
+		{channel: "dev", expected: "C0000000003"},
+		{channel: "#private", expected: "private"},
+	}
+	for _, c := range cases {
+		if got := nwp.orderedKey(t.Context(), c.channel); got != c.expected {
+			t.Errorf("%s: expected key %s, got %s", c.channel, c.expected, got)
+		}
+	}
+	if searched != 2 {
+		t.Errorf("expected to search channels once for each unknown name, searched %d times", searched)
+	}
+
+	// a post to the private channel caches its ID, but the key of the spelling does not change.
+	if err := nwp.cache.SetMulti(t.Context(), []ChannelCacheEntry{
+		{ChannelName: "#private", ChannelID: "G0000000001", TTL: ttl},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := nwp.orderedKey(t.Context(), "private"); got != "private" {
+		t.Errorf("expected the key not to change, got %s", got)
+	}
+}
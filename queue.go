@@ -0,0 +1,543 @@
+package nowpaste
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/slack-go/slack"
+)
+
+const (
+	// DefaultQueueWorkers is the default number of workers to deliver the queued jobs.
+	DefaultQueueWorkers = 4
+	// DefaultQueueMaxAttempts is the default max number of attempts to deliver a queued job.
+	DefaultQueueMaxAttempts = 5
+	// DefaultQueueRetention is the default duration to keep the finished jobs for the job status.
+	DefaultQueueRetention = 24 * time.Hour
+	// DefaultQueueDrainTimeout is the default duration to deliver the queued jobs on shutdown.
+	DefaultQueueDrainTimeout = 30 * time.Second
+)
+
+const (
+	queuePollInterval   = time.Second
+	queueRetryInterval  = 2 * time.Second
+	queueMaxRetryDelay  = 5 * time.Minute
+	queueDrainPollDelay = 100 * time.Millisecond
+	queuePurgeInterval  = time.Minute
+)
+
+const (
+	QueueJobStatusQueued    = "queued"
+	QueueJobStatusDelivered = "delivered"
+	QueueJobStatusFailed    = "failed"
+)
+
+// QueueJob is a content accepted in the async mode, and delivered by the queue workers.
+type QueueJob struct {
+	ID            string           `json:"id"`
+	Source        string           `json:"source"`
+	Content       *Content         `json:"content,omitempty"`
+	Status        string           `json:"status"`
+	Attempts      int              `json:"attempts"`
+	Error         string           `json:"error,omitempty"`
+	Result        *PostResult      `json:"result,omitempty"`
+	Request       *RequestMetadata `json:"request,omitempty"`
+	NextAttemptAt time.Time        `json:"next_attempt_at"`
+	CreatedAt     time.Time        `json:"created_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+}
+
+func (job *QueueJob) finished() bool {
+	return job.Status == QueueJobStatusDelivered || job.Status == QueueJobStatusFailed
+}
+
+// QueueStore stores the queued jobs. The unfinished jobs are listed by every poll of the workers,
+// so ListPending should not read the finished jobs.
+type QueueStore interface {
+	Put(ctx context.Context, job *QueueJob) error
+	Get(ctx context.Context, id string) (job *QueueJob, ok bool, err error)
+	Delete(ctx context.Context, id string) error
+	// ListPending returns the unfinished jobs in the order of creation.
+	ListPending(ctx context.Context) ([]*QueueJob, error)
+	// Purge deletes the finished jobs updated before the time, and returns the number of the deleted jobs.
+	Purge(ctx context.Context, before time.Time) (int, error)
+}
+
+type InmemoryQueueStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*QueueJob
+}
+
+var _ QueueStore = (*InmemoryQueueStore)(nil)
+
+func NewInmemoryQueueStore() *InmemoryQueueStore {
+	return &InmemoryQueueStore{
+		jobs: make(map[string]*QueueJob),
+	}
+}
+
+func (s *InmemoryQueueStore) Put(_ context.Context, job *QueueJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := *job
+	s.jobs[job.ID] = &j
+	return nil
+}
+
+func (s *InmemoryQueueStore) Get(_ context.Context, id string) (*QueueJob, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, false, nil
+	}
+	j := *job
+	return &j, true, nil
+}
+
+func (s *InmemoryQueueStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+	return nil
+}
+
+func (s *InmemoryQueueStore) ListPending(_ context.Context) ([]*QueueJob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	jobs := make([]*QueueJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		if job.finished() {
+			continue
+		}
+		j := *job
+		jobs = append(jobs, &j)
+	}
+	sortQueueJobs(jobs)
+	return jobs, nil
+}
+
+func (s *InmemoryQueueStore) Purge(_ context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for id, job := range s.jobs {
+		if job.finished() && job.UpdatedAt.Before(before) {
+			delete(s.jobs, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func sortQueueJobs(jobs []*QueueJob) {
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].CreatedAt.Equal(jobs[j].CreatedAt) {
+			return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+}
+
+// FileQueueStore persists queued jobs as JSON files in the directory, one file per job.
+// The queued jobs survive restarts, and are delivered by the workers of the next process.
+// The directory is read once on open, and the unfinished jobs are indexed in memory, so the directory must not be shared by processes.
+type FileQueueStore struct {
+	store *fileDirStore[*QueueJob]
+
+	mu       sync.Mutex
+	pending  map[string]*QueueJob
+	finished map[string]time.Time
+}
+
+var _ QueueStore = (*FileQueueStore)(nil)
+
+func NewFileQueueStore(dir string) (*FileQueueStore, error) {
+	store, err := newFileDirStore[*QueueJob](dir, "queued job")
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := store.list()
+	if err != nil {
+		return nil, err
+	}
+	s := &FileQueueStore{
+		store:    store,
+		pending:  make(map[string]*QueueJob),
+		finished: make(map[string]time.Time),
+	}
+	for _, job := range jobs {
+		s.index(job)
+	}
+	return s, nil
+}
+
+func (s *FileQueueStore) index(job *QueueJob) {
+	if job.finished() {
+		delete(s.pending, job.ID)
+		s.finished[job.ID] = job.UpdatedAt
+		return
+	}
+	j := *job
+	s.pending[job.ID] = &j
+	delete(s.finished, job.ID)
+}
+
+func (s *FileQueueStore) Put(_ context.Context, job *QueueJob) error {
+	if err := s.store.put(job.ID, job); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index(job)
+	return nil
+}
+
+func (s *FileQueueStore) Get(_ context.Context, id string) (*QueueJob, bool, error) {
+	return s.store.get(id)
+}
+
+func (s *FileQueueStore) Delete(_ context.Context, id string) error {
+	if err := s.store.delete(id); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, id)
+	delete(s.finished, id)
+	return nil
+}
+
+func (s *FileQueueStore) ListPending(_ context.Context) ([]*QueueJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]*QueueJob, 0, len(s.pending))
+	for _, job := range s.pending {
+		j := *job
+		jobs = append(jobs, &j)
+	}
+	sortQueueJobs(jobs)
+	return jobs, nil
+}
+
+func (s *FileQueueStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	ids := make([]string, 0)
+	for id, updatedAt := range s.finished {
+		if updatedAt.Before(before) {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.Unlock()
+	for i, id := range ids {
+		if err := s.Delete(ctx, id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// enqueue puts the content to the queue, and notifies the workers.
+func (nwp *NowPaste) enqueue(ctx context.Context, source string, content *Content, meta *RequestMetadata) (*QueueJob, error) {
+	now := time.Now()
+	job := &QueueJob{
+		ID:            newID(),
+		Source:        source,
+		Content:       content,
+		Request:       meta,
+		Status:        QueueJobStatusQueued,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := nwp.queue.Put(ctx, job); err != nil {
+		return nil, fmt.Errorf("put queued job: %w", err)
+	}
+	log.Printf("[info] enqueue job `%s` to %s from %s", job.ID, content.Channel, source)
+	select {
+	case nwp.queueNotify <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// validateContent checks the content before enqueueing, for the failures which do not succeed by retrying.
+func validateContent(content *Content) error {
+	if len(splitChannels(content.Channel)) == 0 {
+		return ErrChannelRequired
+	}
+	if !content.IsRich() && len(content.Files) == 0 {
+		return errors.New("content is empty")
+	}
+	if len(content.Blocks) > 0 {
+		var blocks slack.Blocks
+		if err := json.Unmarshal(content.Blocks, &blocks); err != nil {
+			return fmt.Errorf("invalid blocks: %w", err)
+		}
+	}
+	return nil
+}
+
+// postAsync validates and enqueues the content, and answers `202 Accepted` with the job id.
+func (nwp *NowPaste) postAsync(w http.ResponseWriter, req *http.Request, source string, content *Content, meta *RequestMetadata) {
+	if err := validateContent(content); err != nil {
+		log.Printf("[info] %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	job, err := nwp.enqueue(req.Context(), source, content, meta)
+	if err != nil {
+		log.Printf("[error] %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	if !acceptsJSON(req) {
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, job.ID)
+		return
+	}
+	writeQueueJob(w, http.StatusAccepted, job)
+}
+
+func writeQueueJob(w http.ResponseWriter, status int, job *QueueJob) {
+	j := *job
+	j.Content = nil
+	writeJSON(w, status, &j)
+}
+
+func (nwp *NowPaste) getJob(w http.ResponseWriter, req *http.Request) {
+	if nwp.queue == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	job, ok, err := nwp.queue.Get(req.Context(), mux.Vars(req)["id"])
+	if err != nil {
+		log.Printf("[error] get queued job: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	writeQueueJob(w, http.StatusOK, job)
+}
+
+// RunQueueWorkers delivers the queued jobs by the workers until the context is canceled.
+// Jobs failed with transient errors are retried with backoff, and jobs failed with permanent errors or too many attempts
+// are moved to the dead letter store if configured.
+// After the context is canceled, it keeps delivering the jobs which are ready within the drain timeout.
+func (nwp *NowPaste) RunQueueWorkers(ctx context.Context, workers int) error {
+	if nwp.queue == nil {
+		return errors.New("queue store is not configured")
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+	// deliveries continue during the drain, until the drain timeout.
+	deliverCtx, cancelDeliver := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelDeliver()
+	d := &queueDispatcher{
+		jobs:     make(chan *QueueJob),
+		inflight: make(map[string]bool),
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range d.jobs {
+				nwp.deliverQueueJob(deliverCtx, job)
+				d.done(job.ID)
+			}
+		}()
+	}
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		nwp.dispatchQueueJobs(ctx, d, time.Now())
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		case <-nwp.queueNotify:
+		}
+	}
+
+	log.Printf("[info] draining the queue within %s", nwp.queueDrainTimeout)
+	drainCtx, cancelDrain := context.WithTimeout(context.WithoutCancel(ctx), nwp.queueDrainTimeout)
+	defer cancelDrain()
+	for drainCtx.Err() == nil {
+		if nwp.dispatchQueueJobs(drainCtx, d, time.Now()) == 0 && d.idle() {
+			break
+		}
+		select {
+		case <-drainCtx.Done():
+		case <-time.After(queueDrainPollDelay):
+		}
+	}
+	close(d.jobs)
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-drainCtx.Done():
+		log.Printf("[warn] drain timeout, cancel the deliveries in progress")
+		cancelDeliver()
+		<-stopped
+	}
+	if jobs, err := nwp.queue.ListPending(context.WithoutCancel(ctx)); err == nil && len(jobs) > 0 {
+		log.Printf("[warn] %d jobs remain in the queue", len(jobs))
+	}
+	return ctx.Err()
+}
+
+// queueDispatcher hands the queued jobs to the workers, without handing a job in progress twice.
+type queueDispatcher struct {
+	mu       sync.Mutex
+	jobs     chan *QueueJob
+	inflight map[string]bool
+	purgedAt time.Time
+}
+
+func (d *queueDispatcher) start(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.inflight[id] {
+		return false
+	}
+	d.inflight[id] = true
+	return true
+}
+
+func (d *queueDispatcher) done(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.inflight, id)
+}
+
+func (d *queueDispatcher) idle() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.inflight) == 0
+}
+
+// dispatchQueueJobs hands the jobs which are ready to the workers, and purges the finished jobs after the retention once a minute.
+// With the ordered delivery, a job is not handed while an earlier job to the same channel is unfinished.
+// It returns the number of the handed jobs.
+func (nwp *NowPaste) dispatchQueueJobs(ctx context.Context, d *queueDispatcher, now time.Time) int {
+	if now.Sub(d.purgedAt) >= queuePurgeInterval {
+		d.purgedAt = now
+		if n, err := nwp.queue.Purge(ctx, now.Add(-nwp.queueRetention)); err != nil {
+			log.Printf("[warn] purge finished jobs: %s", err.Error())
+		} else if n > 0 {
+			log.Printf("[info] purge %d finished jobs", n)
+		}
+	}
+	jobs, err := nwp.queue.ListPending(ctx)
+	if err != nil {
+		log.Printf("[error] list queued jobs: %s", err.Error())
+		return 0
+	}
+	var n int
+	blocked := make(map[string]bool)
+	for _, job := range jobs {
+		if nwp.ordered != nil && job.Content != nil {
+			waiting := false
+			for _, key := range nwp.orderedKeys(ctx, splitChannels(job.Content.Channel)) {
+				waiting = waiting || blocked[key]
+				blocked[key] = true
+			}
+			if waiting {
+				continue
+			}
+		}
+		if job.NextAttemptAt.After(now) || !d.start(job.ID) {
+			continue
+		}
+		select {
+		case d.jobs <- job:
+			n++
+		case <-ctx.Done():
+			d.done(job.ID)
+			return n
+		}
+	}
+	return n
+}
+
+// deliverQueueJob posts the content of the job, and records the status of the job.
+func (nwp *NowPaste) deliverQueueJob(ctx context.Context, job *QueueJob) {
+	content := *job.Content
+	result, err := nwp.postContent(ctx, &content)
+	if err != nil && ctx.Err() != nil {
+		log.Printf("[warn] delivery of job `%s` is canceled, retry later: %s", job.ID, err.Error())
+		return
+	}
+	now := time.Now()
+	job.Attempts++
+	job.UpdatedAt = now
+	switch {
+	case err == nil:
+		log.Printf("[info] deliver job `%s` to %s", job.ID, result.ChannelID)
+		job.Status = QueueJobStatusDelivered
+		job.Result = result
+		job.Error = ""
+	case !IsPermanentError(err) && job.Attempts < nwp.queueMaxAttempts:
+		delay := queueRetryDelay(job.Attempts, err)
+		log.Printf("[warn] deliver job `%s` (attempt %d), retry after %s: %s", job.ID, job.Attempts, delay, err.Error())
+		job.Error = err.Error()
+		job.NextAttemptAt = now.Add(delay)
+	default:
+		log.Printf("[error] deliver job `%s` (attempt %d): %s", job.ID, job.Attempts, err.Error())
+		job.Status = QueueJobStatusFailed
+		job.Error = err.Error()
+		if nwp.deadLetters != nil {
+			meta := &RequestMetadata{}
+			if job.Request != nil {
+				*meta = *job.Request
+			}
+			meta.JobID = job.ID
+			dl := &DeadLetter{
+				Source:   job.Source,
+				Content:  job.Content,
+				Attempts: job.Attempts,
+				Request:  meta,
+			}
+			if err := nwp.putDeadLetter(ctx, dl, err); err != nil {
+				log.Printf("[error] %s", err.Error())
+			}
+		}
+	}
+	if job.finished() {
+		// the content of a failed job is kept by the dead letter.
+		job.Content = nil
+	}
+	if err := nwp.queue.Put(ctx, job); err != nil {
+		log.Printf("[error] put queued job `%s`: %s", job.ID, err.Error())
+	}
+}
+
+// queueRetryDelay returns the delay before the next attempt, which doubles by the attempts, or Retry-After of the rate limit.
+func queueRetryDelay(attempts int, err error) time.Duration {
+	delay := queueMaxRetryDelay
+	if attempts < 16 {
+		delay = min(queueRetryInterval<<(attempts-1), queueMaxRetryDelay)
+	}
+	var rle *slack.RateLimitedError
+	if errors.As(err, &rle) && rle.RetryAfter > delay {
+		delay = rle.RetryAfter
+	}
+	return delay
+}
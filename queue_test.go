@@ -0,0 +1,239 @@
+package nowpaste
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failPostMessage answers the error for posting messages, until the count of failures.
+func failPostMessage(slackErr string, failures int64) middleware {
+	var count int64
+	return func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/chat.postMessage" && atomic.AddInt64(&count, 1) <= failures {
+				io.WriteString(w, `{"ok":false,"error":"`+slackErr+`"}`)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+func getQueueJob(t *testing.T, nwp *NowPaste, id string) *QueueJob {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/jobs/"+id, nil)
+	w := httptest.NewRecorder()
+	nwp.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("get job %s: unexpected status %d", id, w.Code)
+	}
+	var job QueueJob
+	if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	return &job
+}
+
+func TestPostAsync(t *testing.T) {
+	var count int64
+	nwp := newMockedNowPaste(t, io.Discard, countPostMessage(&count))
+	nwp.SetQueueStore(NewInmemoryQueueStore())
+	req := httptest.NewRequest(http.MethodPost, "/?channel=C0123456789", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	nwp.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	var accepted QueueJob
+	if err := json.NewDecoder(w.Body).Decode(&accepted); err != nil {
+		t.Fatal(err)
+	}
+	if accepted.ID == "" || accepted.Status != QueueJobStatusQueued || accepted.Content != nil {
+		t.Fatalf("unexpected job: %#v", accepted)
+	}
+	if count != 0 {
+		t.Errorf("expected not to post in the request, posted %d times", count)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error)
+	go func() {
+		done <- nwp.RunQueueWorkers(ctx, 2)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	job := getQueueJob(t, nwp, accepted.ID)
+	for job.Status == QueueJobStatusQueued && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		job = getQueueJob(t, nwp, accepted.ID)
+	}
+	cancel()
+	<-done
+	if job.Status != QueueJobStatusDelivered || job.Attempts != 1 || job.Result == nil || job.Result.ChannelID != "C123456" {
+		t.Errorf("unexpected job: %#v", job)
+	}
+	if count != 1 {
+		t.Errorf("expected to post once, posted %d times", count)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/jobs/unknown", nil)
+	w = httptest.NewRecorder()
+	nwp.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown job, got %d", w.Code)
+	}
+}
+
+func TestPostAsyncInvalidContent(t *testing.T) {
+	nwp := newMockedNowPaste(t, io.Discard)
+	store := NewInmemoryQueueStore()
+	nwp.SetQueueStore(store)
+	for body, contentType := range map[string]string{
+		"": "text/plain",
+		`{"channel":"C0123456789","blocks":{"type":"section"}}`: "application/json",
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/?channel=C0123456789", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		nwp.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+	if jobs, _ := store.ListPending(t.Context()); len(jobs) != 0 {
+		t.Errorf("expected no jobs, got %d", len(jobs))
+	}
+}
+
+func TestDeliverQueueJob(t *testing.T) {
+	cases := []struct {
+		name           string
+		middleware     middleware
+		maxAttempts    int
+		expectedStatus string
+	}{
+		{name: "transient", middleware: failPostMessage("internal_error", 1), maxAttempts: 5, expectedStatus: QueueJobStatusQueued},
+		{name: "permanent", middleware: failPostMessage("channel_not_found", 1), maxAttempts: 5, expectedStatus: QueueJobStatusFailed},
+		{name: "max_attempts", middleware: failPostMessage("internal_error", 1), maxAttempts: 1, expectedStatus: QueueJobStatusFailed},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			nwp := newMockedNowPaste(t, io.Discard, c.middleware)
+			nwp.SetQueueStore(NewInmemoryQueueStore())
+			nwp.SetQueueMaxAttempts(c.maxAttempts)
+			// retries of the queue, not of the Slack API call.
+			nwp.SetRetryMaxAttempts(1)
+			dlq := NewInmemoryDeadLetterStore()
+			nwp.SetDeadLetterStore(dlq)
+			job, err := nwp.enqueue(t.Context(), "default", &Content{Channel: "C0123456789", Text: "hello"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nwp.deliverQueueJob(t.Context(), job)
+			got, _, _ := nwp.queue.Get(t.Context(), job.ID)
+			if got.Status != c.expectedStatus || got.Attempts != 1 || got.Error == "" {
+				t.Errorf("unexpected job: %#v", got)
+			}
+			if dls, _ := dlq.List(t.Context()); c.expectedStatus == QueueJobStatusFailed {
+				if len(dls) != 1 || dls[0].Attempts != 1 || dls[0].Request.JobID != job.ID {
+					t.Errorf("expected a dead letter of the job, got %#v", dls)
+				}
+			}
+			if c.expectedStatus == QueueJobStatusQueued {
+				if !got.NextAttemptAt.After(time.Now()) {
+					t.Errorf("expected to retry later, next attempt at %s", got.NextAttemptAt)
+				}
+				nwp.deliverQueueJob(t.Context(), got)
+				got, _, _ = nwp.queue.Get(t.Context(), job.ID)
+				if got.Status != QueueJobStatusDelivered || got.Attempts != 2 {
+					t.Errorf("expected to be delivered by retrying, got %#v", got)
+				}
+			}
+		})
+	}
+}
+
+func TestRunQueueWorkersDrain(t *testing.T) {
+	var count int64
+	nwp := newMockedNowPaste(t, io.Discard, countPostMessage(&count))
+	store, err := NewFileQueueStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nwp.SetQueueStore(store)
+	ids := make([]string, 0, 3)
+	for _, text := range []string{"first", "second", "third"} {
+		job, err := nwp.enqueue(t.Context(), "default", &Content{Channel: "C0123456789", Text: text}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, job.ID)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if err := nwp.RunQueueWorkers(ctx, 2); err != context.Canceled {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected to drain 3 jobs, posted %d times", count)
+	}
+	for _, id := range ids {
+		job, ok, err := store.Get(t.Context(), id)
+		if err != nil || !ok {
+			t.Fatalf("get job %s: %v", id, err)
+		}
+		if job.Status != QueueJobStatusDelivered {
+			t.Errorf("job %s: unexpected status %s", id, job.Status)
+		}
+	}
+}
+
+func TestFileQueueStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileQueueStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	jobs := []*QueueJob{
+		{ID: "queued", Status: QueueJobStatusQueued, CreatedAt: now, UpdatedAt: now},
+		{ID: "delivered", Status: QueueJobStatusDelivered, CreatedAt: now.Add(-2 * time.Hour), UpdatedAt: now.Add(-2 * time.Hour)},
+		{ID: "failed", Status: QueueJobStatusFailed, CreatedAt: now, UpdatedAt: now},
+	}
+	for _, job := range jobs {
+		if err := store.Put(t.Context(), job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the unfinished jobs are indexed again on open.
+	reopened, err := NewFileQueueStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*FileQueueStore{store, reopened} {
+		pending, err := s.ListPending(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 1 || pending[0].ID != "queued" {
+			t.Errorf("unexpected pending jobs: %#v", pending)
+		}
+	}
+	if n, err := reopened.Purge(t.Context(), now.Add(-time.Hour)); err != nil || n != 1 {
+		t.Errorf("expected to purge 1 job, purged %d: %v", n, err)
+	}
+	if _, ok, _ := reopened.Get(t.Context(), "delivered"); ok {
+		t.Error("expected the old finished job to be purged")
+	}
+	if _, ok, _ := reopened.Get(t.Context(), "failed"); !ok {
+		t.Error("expected the recent finished job to be kept")
+	}
+}
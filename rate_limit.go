@@ -0,0 +1,265 @@
+package nowpaste
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateTier is the rate limit of Slack API methods, in the interval between calls and the burst of calls.
+// see also https://api.slack.com/apis/rate-limits
+type rateTier struct {
+	Name     string
+	Interval time.Duration
+	Burst    int
+	// PerChannel limits the calls per channel, instead of per method.
+	PerChannel bool
+}
+
+// rateLimitSweepInterval is the interval to drop the theoretical arrival times in the past, which are the same as no calls.
+const rateLimitSweepInterval = time.Minute
+
+var (
+	rateTier2 = rateTier{Name: "tier2", Interval: 3 * time.Second, Burst: 3}
+	rateTier3 = rateTier{Name: "tier3", Interval: 1200 * time.Millisecond, Burst: 5}
+	rateTier4 = rateTier{Name: "tier4", Interval: 600 * time.Millisecond, Burst: 10}
+	// chat.postMessage allows 1 message per second per channel, with short bursts.
+	rateTierPostMessage = rateTier{Name: "special", Interval: time.Second, Burst: 3, PerChannel: true}
+)
+
+// slackMethodTiers are the rate limit tiers of the Slack API methods nowpaste calls. The other methods are in tier 3.
+var slackMethodTiers = map[string]rateTier{
+	"chat.postMessage":             rateTierPostMessage,
+	"chat.update":                  rateTier3,
+	"chat.delete":                  rateTier3,
+	"chat.getPermalink":            rateTier4,
+	"chat.scheduleMessage":         rateTier3,
+	"chat.scheduledMessages.list":  rateTier3,
+	"chat.deleteScheduledMessage":  rateTier3,
+	"conversations.list":           rateTier2,
+	"conversations.join":           rateTier3,
+	"conversations.open":           rateTier3,
+	"conversations.history":        rateTier3,
+	"conversations.info":           rateTier3,
+	"files.upload":                 rateTier2,
+	"files.info":                   rateTier4,
+	"files.getUploadURLExternal":   rateTier4,
+	"files.completeUploadExternal": rateTier4,
+	"users.list":                   rateTier2,
+	"users.lookupByEmail":          rateTier3,
+}
+
+type httpDoer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// rateLimiter is a process-wide rate limiter in front of the HTTP client of Slack API.
+// It spaces the calls by the tier of the method (and the channel for chat.postMessage),
+// and when a call is rate limited, it pauses all the calls of the method until Retry-After elapses.
+type rateLimiter struct {
+	mu          sync.Mutex
+	next        httpDoer
+	tiers       map[string]rateTier
+	defaultTier rateTier
+	disabled    bool
+	// tats are the theoretical arrival times of the next calls, by method or method and channel.
+	tats    map[string]time.Time
+	sweptAt time.Time
+	paused  map[string]time.Time
+	stats   map[string]*RateLimitStats
+}
+
+// RateLimitStats are the stats of the rate limiter for a Slack API method.
+type RateLimitStats struct {
+	Method string `json:"method"`
+	Tier   string `json:"tier"`
+	Calls  int64  `json:"calls"`
+	// Waited is the number of the calls which waited for the rate limit.
+	Waited           int64      `json:"waited"`
+	WaitSecondsTotal float64    `json:"wait_seconds_total"`
+	WaitSecondsMax   float64    `json:"wait_seconds_max"`
+	RateLimited      int64      `json:"rate_limited"`
+	PausedUntil      *time.Time `json:"paused_until,omitempty"`
+}
+
+func newRateLimiter(next httpDoer) *rateLimiter {
+	return &rateLimiter{
+		next:        next,
+		tiers:       slackMethodTiers,
+		defaultTier: rateTier3,
+		tats:        make(map[string]time.Time),
+		paused:      make(map[string]time.Time),
+		stats:       make(map[string]*RateLimitStats),
+	}
+}
+
+func (l *rateLimiter) setEnabled(enabled bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.disabled = !enabled
+}
+
+func (l *rateLimiter) tier(method string) rateTier {
+	if tier, ok := l.tiers[method]; ok {
+		return tier
+	}
+	return l.defaultTier
+}
+
+// Do waits for the rate limit of the method of the request, and calls the next client.
+func (l *rateLimiter) Do(req *http.Request) (*http.Response, error) {
+	method, ok := slackMethod(req)
+	l.mu.Lock()
+	disabled := l.disabled
+	l.mu.Unlock()
+	if !ok || disabled {
+		return l.next.Do(req)
+	}
+	tier := l.tier(method)
+	key := method
+	if tier.PerChannel {
+		if channel := requestChannel(req); channel != "" {
+			key = method + "\x00" + channel
+		}
+	}
+	wait := l.reserve(method, key, tier, time.Now())
+	if wait > 0 {
+		log.Printf("[debug] wait %s for the rate limit of %s", wait, method)
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-req.Context().Done():
+			t.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+	resp, err := l.next.Do(req)
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		l.pause(method, resp.Header.Get("Retry-After"), time.Now())
+	}
+	return resp, err
+}
+
+// reserve reserves a call of the key, and returns the duration to wait for the call.
+// The calls are spaced by the generic cell rate algorithm, which allows the burst of calls.
+func (l *rateLimiter) reserve(method string, key string, tier rateTier, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.sweptAt) >= rateLimitSweepInterval {
+		l.sweptAt = now
+		for k, tat := range l.tats {
+			if tat.Before(now) {
+				delete(l.tats, k)
+			}
+		}
+	}
+	tat := l.tats[key]
+	if tat.Before(now) {
+		tat = now
+	}
+	pausedUntil := l.paused[method]
+	if pausedUntil.After(tat) {
+		tat = pausedUntil
+	}
+	start := tat.Add(-time.Duration(max(tier.Burst-1, 0)) * tier.Interval)
+	if pausedUntil.After(start) {
+		start = pausedUntil
+	}
+	l.tats[key] = tat.Add(tier.Interval)
+	wait := max(start.Sub(now), 0)
+	stats := l.methodStats(method, tier)
+	stats.Calls++
+	if wait > 0 {
+		stats.Waited++
+		stats.WaitSecondsTotal += wait.Seconds()
+		stats.WaitSecondsMax = max(stats.WaitSecondsMax, wait.Seconds())
+	}
+	return wait
+}
+
+// pause pauses all the calls of the method until Retry-After elapses.
+func (l *rateLimiter) pause(method string, retryAfter string, now time.Time) {
+	seconds, err := strconv.ParseInt(retryAfter, 10, 64)
+	if err != nil || seconds <= 0 {
+		seconds = 1
+	}
+	until := now.Add(time.Duration(seconds) * time.Second)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until.After(l.paused[method]) {
+		l.paused[method] = until
+	}
+	l.methodStats(method, l.tier(method)).RateLimited++
+	log.Printf("[warn] %s is rate limited, pause the calls until %s", method, until.Format(time.RFC3339))
+}
+
+// methodStats returns the stats of the method. Must be called with the lock held.
+func (l *rateLimiter) methodStats(method string, tier rateTier) *RateLimitStats {
+	stats, ok := l.stats[method]
+	if !ok {
+		stats = &RateLimitStats{Method: method, Tier: tier.Name}
+		l.stats[method] = stats
+	}
+	return stats
+}
+
+// Stats returns the stats of the methods called, sorted by the method.
+func (l *rateLimiter) Stats(now time.Time) []RateLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := make([]RateLimitStats, 0, len(l.stats))
+	for method, s := range l.stats {
+		copied := *s
+		if pausedUntil, ok := l.paused[method]; ok && pausedUntil.After(now) {
+			copied.PausedUntil = &pausedUntil
+		}
+		stats = append(stats, copied)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Method < stats[j].Method
+	})
+	return stats
+}
+
+// slackMethod returns the Slack API method of the request, e.g. `chat.postMessage` of `https://slack.com/api/chat.postMessage`.
+func slackMethod(req *http.Request) (string, bool) {
+	_, method, ok := strings.Cut(req.URL.Path, "/api/")
+	if !ok || method == "" || strings.Contains(method, "/") {
+		return "", false
+	}
+	return method, true
+}
+
+// requestChannel returns the channel of the form request, and restores the body to be sent.
+func requestChannel(req *http.Request) string {
+	if req.Body == nil || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return req.URL.Query().Get("channel")
+	}
+	bs, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(bs))
+	if err != nil {
+		return ""
+	}
+	values, err := url.ParseQuery(string(bs))
+	if err != nil {
+		return ""
+	}
+	return values.Get("channel")
+}
+
+// getRateLimits answers the stats of the rate limiter.
+func (nwp *NowPaste) getRateLimits(w http.ResponseWriter, req *http.Request) {
+	if nwp.limiter == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, nwp.limiter.Stats(time.Now()))
+}
@@ -0,0 +1,147 @@
+package nowpaste
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type doerFunc func(*http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := newRateLimiter(nil)
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		key    string
+		method string
+		tier   rateTier
+		at     time.Duration
+		expect time.Duration
+	}{
+		{key: "chat.postMessage\x00C1", method: "chat.postMessage", tier: rateTierPostMessage, expect: 0},
+		{key: "chat.postMessage\x00C1", method: "chat.postMessage", tier: rateTierPostMessage, expect: 0},
+		{key: "chat.postMessage\x00C1", method: "chat.postMessage", tier: rateTierPostMessage, expect: 0},
+		{key: "chat.postMessage\x00C1", method: "chat.postMessage", tier: rateTierPostMessage, expect: time.Second},
+		{key: "chat.postMessage\x00C2", method: "chat.postMessage", tier: rateTierPostMessage, expect: 0},
+		{key: "chat.postMessage\x00C1", method: "chat.postMessage", tier: rateTierPostMessage, at: 4 * time.Second, expect: 0},
+		{key: "users.list", method: "users.list", tier: rateTier2, expect: 0},
+	}
+	for i, c := range cases {
+		if got := l.reserve(c.method, c.key, c.tier, now.Add(c.at)); got != c.expect {
+			t.Errorf("case %d: expected wait %s, got %s", i, c.expect, got)
+		}
+	}
+
+	l.pause("chat.postMessage", "3", now)
+	if got := l.reserve("chat.postMessage", "chat.postMessage\x00C3", rateTierPostMessage, now); got != 3*time.Second {
+		t.Errorf("expected to wait until the pause ends, got %s", got)
+	}
+	if got := l.reserve("users.list", "users.list", rateTier2, now); got != 0 {
+		t.Errorf("expected the other method not to be paused, got %s", got)
+	}
+	stats := l.Stats(now)
+	if len(stats) != 2 || stats[0].Method != "chat.postMessage" || stats[1].Method != "users.list" {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+	if s := stats[0]; s.Calls != 7 || s.Waited != 2 || s.WaitSecondsMax != 3 || s.RateLimited != 1 || s.PausedUntil == nil {
+		t.Errorf("unexpected stats: %#v", s)
+	}
+	if s := l.Stats(now.Add(time.Minute))[0]; s.PausedUntil != nil {
+		t.Errorf("expected the pause to be ended: %#v", s)
+	}
+
+	// the arrival times in the past are dropped by the sweep.
+	l = newRateLimiter(nil)
+	for _, key := range []string{"chat.postMessage\x00C1", "chat.postMessage\x00C2"} {
+		l.reserve("chat.postMessage", key, rateTierPostMessage, now)
+	}
+	l.reserve("chat.postMessage", "chat.postMessage\x00C3", rateTierPostMessage, now.Add(time.Hour))
+	if got := len(l.tats); got != 1 {
+		t.Errorf("expected the arrival times in the past to be dropped, got %d", got)
+	}
+}
+
+func TestRateLimiterDo(t *testing.T) {
+	var mu sync.Mutex
+	var channels []string
+	limited := true
+	l := newRateLimiter(doerFunc(func(req *http.Request) (*http.Response, error) {
+		bs, _ := io.ReadAll(req.Body)
+		values, _ := url.ParseQuery(string(bs))
+		mu.Lock()
+		defer mu.Unlock()
+		channels = append(channels, values.Get("channel"))
+		w := httptest.NewRecorder()
+		if limited {
+			limited = false
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return w.Result(), nil
+		}
+		io.WriteString(w, `{"ok":true}`)
+		return w.Result(), nil
+	}))
+	l.tiers = map[string]rateTier{
+		"chat.postMessage": {Name: "test", Interval: 50 * time.Millisecond, Burst: 1, PerChannel: true},
+	}
+	post := func(channel string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "https://slack.com/api/chat.postMessage", strings.NewReader(url.Values{"channel": {channel}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := l.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	post("C1")
+	start := time.Now()
+	post("C2")
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("expected to wait for Retry-After, elapsed %s", elapsed)
+	}
+	if len(channels) != 2 || channels[0] != "C1" || channels[1] != "C2" {
+		t.Errorf("expected the body to be restored, got %v", channels)
+	}
+
+	l.setEnabled(false)
+	start = time.Now()
+	for range 3 {
+		post("C1")
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected not to wait when disabled, elapsed %s", elapsed)
+	}
+}
+
+func TestGetRateLimits(t *testing.T) {
+	nwp := newMockedNowPaste(t, io.Discard)
+	req := httptest.NewRequest(http.MethodGet, "/admin/rate-limits", nil)
+	w := httptest.NewRecorder()
+	nwp.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without the rate limiter, got %d", w.Code)
+	}
+
+	nwp.limiter = newRateLimiter(nil)
+	nwp.limiter.reserve("chat.postMessage", "chat.postMessage", rateTierPostMessage, time.Now())
+	w = httptest.NewRecorder()
+	nwp.ServeHTTP(w, req)
+	var stats []RateLimitStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Method != "chat.postMessage" || stats[0].Tier != "special" || stats[0].Calls != 1 {
+		t.Errorf("unexpected stats: %#v", stats)
+	}
+}
@@ -0,0 +1,333 @@
+package nowpaste
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// RoutingModeFirst routes the request by the first matched rule.
+	RoutingModeFirst = "first"
+	// RoutingModeAll routes the request to the channels of all the matched rules.
+	RoutingModeAll = "all"
+)
+
+// RoutingConfig routes requests without explicit channels to channels, by the properties of the request.
+type RoutingConfig struct {
+	Mode  string        `json:"mode,omitempty"`
+	Rules []RoutingRule `json:"rules"`
+	// Templates are the named templates in addition to the templates directory.
+	Templates map[string]string `json:"templates,omitempty"`
+}
+
+// RoutingRule is a rule to route the matched requests to the channel, with the defaults of the presentation.
+type RoutingRule struct {
+	Name      string       `json:"name,omitempty"`
+	Match     RoutingMatch `json:"match"`
+	Channel   string       `json:"channel"`
+	Username  string       `json:"username,omitempty"`
+	IconEmoji string       `json:"icon_emoji,omitempty"`
+	IconURL   string       `json:"icon_url,omitempty"`
+	AsFile    bool         `json:"as_file,omitempty"`
+	AsMessage bool         `json:"as_message,omitempty"`
+	Template  string       `json:"template,omitempty"`
+}
+
+// RoutingMatch is the conditions of a rule, and all the conditions must match. Empty conditions match anything.
+// Path and TopicArn are glob patterns (see path.Match), and the others are regular expressions.
+// Fields are looked up by dot separated paths in the JSON payload, e.g. `alerts.0.labels.severity`.
+type RoutingMatch struct {
+	Path              string            `json:"path,omitempty"`
+	TopicArn          string            `json:"topic_arn,omitempty"`
+	Subject           string            `json:"subject,omitempty"`
+	MessageAttributes map[string]string `json:"message_attributes,omitempty"`
+	Fields            map[string]string `json:"fields,omitempty"`
+	Headers           map[string]string `json:"headers,omitempty"`
+
+	subject           *regexp.Regexp
+	messageAttributes map[string]*regexp.Regexp
+	fields            map[string]*regexp.Regexp
+	headers           map[string]*regexp.Regexp
+}
+
+// RoutingRequest is the properties of a request to be routed.
+type RoutingRequest struct {
+	Path              string            `json:"path,omitempty"`
+	TopicArn          string            `json:"topic_arn,omitempty"`
+	Subject           string            `json:"subject,omitempty"`
+	MessageAttributes map[string]string `json:"message_attributes,omitempty"`
+	Headers           map[string]string `json:"headers,omitempty"`
+	Payload           json.RawMessage   `json:"payload,omitempty"`
+}
+
+// ParseRoutingConfig parses the routing config in YAML or JSON.
+func ParseRoutingConfig(data []byte) (*RoutingConfig, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("parse routing config: %w", err)
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("parse routing config: %w", err)
+	}
+	var config RoutingConfig
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return nil, fmt.Errorf("parse routing config: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Validate validates the config, and compiles the regular expressions of the rules.
+func (c *RoutingConfig) Validate() error {
+	switch c.Mode {
+	case "":
+		c.Mode = RoutingModeFirst
+	case RoutingModeFirst, RoutingModeAll:
+	default:
+		return fmt.Errorf("unknown routing mode `%s`", c.Mode)
+	}
+	for i := range c.Rules {
+		if err := c.Rules[i].validate(); err != nil {
+			return fmt.Errorf("routing rules[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (r *RoutingRule) validate() error {
+	if r.Channel == "" {
+		return errors.New("channel is required")
+	}
+	if r.AsFile && r.AsMessage {
+		return errors.New("as_file and as_message are exclusive")
+	}
+	m := &r.Match
+	for _, pattern := range []string{m.Path, m.TopicArn} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern `%s`: %w", pattern, err)
+		}
+	}
+	var err error
+	if m.Subject != "" {
+		if m.subject, err = regexp.Compile(m.Subject); err != nil {
+			return fmt.Errorf("invalid subject: %w", err)
+		}
+	}
+	if m.messageAttributes, err = compileRegexps(m.MessageAttributes); err != nil {
+		return fmt.Errorf("invalid message_attributes: %w", err)
+	}
+	if m.fields, err = compileRegexps(m.Fields); err != nil {
+		return fmt.Errorf("invalid fields: %w", err)
+	}
+	if m.headers, err = compileRegexps(m.Headers); err != nil {
+		return fmt.Errorf("invalid headers: %w", err)
+	}
+	return nil
+}
+
+func compileRegexps(patterns map[string]string) (map[string]*regexp.Regexp, error) {
+	compiled := make(map[string]*regexp.Regexp, len(patterns))
+	for key, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		compiled[key] = re
+	}
+	return compiled, nil
+}
+
+// Route returns the matched rules for the request. In the first mode, it returns the first matched rule only.
+func (c *RoutingConfig) Route(req *RoutingRequest) []*RoutingRule {
+	var payload interface{}
+	if len(req.Payload) > 0 {
+		if err := json.Unmarshal(req.Payload, &payload); err != nil {
+			log.Printf("[debug] routing payload is not JSON: %s", err.Error())
+			payload = nil
+		}
+	}
+	matched := make([]*RoutingRule, 0, 1)
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if !rule.Match.match(req, payload) {
+			continue
+		}
+		log.Printf("[debug] routing rule[%d] `%s` matched", i, rule.Name)
+		matched = append(matched, rule)
+		if c.Mode != RoutingModeAll {
+			break
+		}
+	}
+	return matched
+}
+
+func (m *RoutingMatch) match(req *RoutingRequest, payload interface{}) bool {
+	if m.Path != "" {
+		if ok, _ := path.Match(m.Path, req.Path); !ok {
+			return false
+		}
+	}
+	if m.TopicArn != "" {
+		if ok, _ := path.Match(m.TopicArn, req.TopicArn); !ok {
+			return false
+		}
+	}
+	if m.subject != nil && !m.subject.MatchString(req.Subject) {
+		return false
+	}
+	for key, re := range m.messageAttributes {
+		v, ok := req.MessageAttributes[key]
+		if !ok || !re.MatchString(v) {
+			return false
+		}
+	}
+	for key, re := range m.headers {
+		v, ok := lookupHeader(req.Headers, key)
+		if !ok || !re.MatchString(v) {
+			return false
+		}
+	}
+	for key, re := range m.fields {
+		v, ok := lookupJSONPath(payload, key)
+		if !ok || !re.MatchString(jsonValueString(v)) {
+			return false
+		}
+	}
+	return true
+}
+
+func lookupHeader(headers map[string]string, key string) (string, bool) {
+	for k, v := range headers {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// lookupJSONPath looks up the value of the dot separated path in the decoded JSON, where array elements are addressed by index.
+func lookupJSONPath(v interface{}, jsonPath string) (interface{}, bool) {
+	if jsonPath == "" {
+		return v, true
+	}
+	for _, key := range strings.Split(jsonPath, ".") {
+		switch o := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = o[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(o) {
+				return nil, false
+			}
+			v = o[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// jsonValueString returns the string of the decoded JSON value, strings as is and the others in JSON.
+func jsonValueString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64)
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(bs)
+}
+
+// ApplyRoutingRules sets the channels of the rules to the content, and the defaults of the first rule unless the content has them.
+func ApplyRoutingRules(content *Content, rules []*RoutingRule) {
+	if len(rules) == 0 {
+		return
+	}
+	channels := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		channels = append(channels, rule.Channel)
+	}
+	content.Channel = strings.Join(channels, channelSeparator)
+	rule := rules[0]
+	if content.Username == "" || content.Username == defaultUsername {
+		if rule.Username != "" {
+			content.Username = rule.Username
+		}
+	}
+	if content.IconEmoji == "" && content.IconURL == "" {
+		content.IconEmoji = rule.IconEmoji
+		content.IconURL = rule.IconURL
+	}
+	if !content.AsFile && !content.AsMessage {
+		content.AsFile = rule.AsFile
+		content.AsMessage = rule.AsMessage
+	}
+	if content.template == "" {
+		content.template = rule.Template
+	}
+}
+
+// newRoutingRequest returns the routing request of the HTTP request.
+func newRoutingRequest(req *http.Request, payload []byte) *RoutingRequest {
+	headers := make(map[string]string, len(req.Header))
+	for key := range req.Header {
+		headers[key] = req.Header.Get(key)
+	}
+	return &RoutingRequest{
+		Path:    req.URL.Path,
+		Headers: headers,
+		Payload: payload,
+	}
+}
+
+// newSNSRoutingRequest returns the routing request of the Amazon SNS message.
+func newSNSRoutingRequest(req *http.Request, n *HTTPNotification) *RoutingRequest {
+	routingReq := newRoutingRequest(req, nil)
+	routingReq.TopicArn = n.TopicArn
+	routingReq.Subject = n.Subject
+	routingReq.MessageAttributes = make(map[string]string, len(n.MessageAttributes))
+	for key, attr := range n.MessageAttributes {
+		routingReq.MessageAttributes[key] = attr.Value
+	}
+	if json.Valid([]byte(n.Message)) {
+		routingReq.Payload = json.RawMessage(n.Message)
+	}
+	return routingReq
+}
+
+// route routes the content by the routing config, and reports whether any rule matched.
+func (nwp *NowPaste) route(content *Content, routingReq *RoutingRequest) bool {
+	if nwp.routes == nil {
+		return false
+	}
+	rules := nwp.routes.Route(routingReq)
+	if len(rules) == 0 {
+		log.Printf("[info] no routing rules matched for %s", routingReq.Path)
+		return false
+	}
+	ApplyRoutingRules(content, rules)
+	log.Printf("[info] routed to %s", content.Channel)
+	return true
+}
@@ -0,0 +1,232 @@
+package nowpaste
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func loadTestRoutingConfig(t *testing.T) *RoutingConfig {
+	t.Helper()
+	bs, err := os.ReadFile("testdata/routes.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := ParseRoutingConfig(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestRoutingConfigRoute(t *testing.T) {
+	config := loadTestRoutingConfig(t)
+	cases := []struct {
+		name     string
+		req      RoutingRequest
+		mode     string
+		expected []string
+	}{
+		{
+			name: "critical",
+			req: RoutingRequest{
+				TopicArn:          "arn:aws:sns:ap-northeast-1:123456789012:alarms-api",
+				Subject:           "ALARM: api 5xx",
+				MessageAttributes: map[string]string{"severity": "critical"},
+			},
+			expected: []string{"critical alarms"},
+		},
+		{
+			name: "critical_all",
+			req: RoutingRequest{
+				TopicArn:          "arn:aws:sns:ap-northeast-1:123456789012:alarms-api",
+				Subject:           "ALARM: api 5xx",
+				MessageAttributes: map[string]string{"severity": "critical"},
+			},
+			mode:     RoutingModeAll,
+			expected: []string{"critical alarms", "alarms"},
+		},
+		{
+			name: "low_severity",
+			req: RoutingRequest{
+				TopicArn:          "arn:aws:sns:ap-northeast-1:123456789012:alarms-api",
+				Subject:           "ALARM: api 5xx",
+				MessageAttributes: map[string]string{"severity": "low"},
+			},
+			expected: []string{"alarms"},
+		},
+		{
+			name: "headers_and_fields",
+			req: RoutingRequest{
+				Path:    "/",
+				Headers: map[string]string{"X-Github-Event": "deployment_status"},
+				Payload: json.RawMessage(`{"deployment_status":{"state":"success"}}`),
+			},
+			expected: []string{"github deployments"},
+		},
+		{
+			name: "array_field",
+			req: RoutingRequest{
+				Path:    "/",
+				Payload: json.RawMessage(`{"build":{"logs":[{"level":"error"}]}}`),
+			},
+			expected: []string{"ci logs"},
+		},
+		{
+			name: "no_match",
+			req: RoutingRequest{
+				Path:    "/",
+				Payload: json.RawMessage(`{"build":{"logs":[]}}`),
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config.Mode = RoutingModeFirst
+			if c.mode != "" {
+				config.Mode = c.mode
+			}
+			rules := config.Route(&c.req)
+			names := make([]string, 0, len(rules))
+			for _, rule := range rules {
+				names = append(names, rule.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(c.expected, ",") {
+				t.Errorf("expected %v, got %v", c.expected, names)
+			}
+		})
+	}
+}
+
+func TestParseRoutingConfigInvalid(t *testing.T) {
+	cases := []string{
+		`{"rules":[{"match":{}}]}`,
+		`{"mode":"any","rules":[]}`,
+		`{"rules":[{"channel":"#ops","match":{"subject":"("}}]}`,
+		`{"rules":[{"channel":"#ops","match":{"unknown":"x"}}]}`,
+		`rules: [{channel: "#ops", as_file: true, as_message: true}]`,
+	}
+	for _, c := range cases {
+		if _, err := ParseRoutingConfig([]byte(c)); err == nil {
+			t.Errorf("expected error for %s", c)
+		}
+	}
+}
+
+func TestApplyRoutingRules(t *testing.T) {
+	config := loadTestRoutingConfig(t)
+	content := &Content{Username: defaultUsername, IconURL: "https://example.com/icon.png"}
+	ApplyRoutingRules(content, []*RoutingRule{&config.Rules[0], &config.Rules[1]})
+	if content.Channel != "#ops,#oncall,#ops" || content.Username != "alarm" || content.IconEmoji != "" {
+		t.Errorf("unexpected content: %#v", content)
+	}
+}
+
+func TestPostSNSRoutedLifecycle(t *testing.T) {
+	var mu sync.Mutex
+	posted := make(map[string]int)
+	nwp := newMockedNowPaste(t, io.Discard, mockChannelNotFound("", &mu, posted))
+	if err := nwp.SetRoutingConfig(loadTestRoutingConfig(t)); err != nil {
+		t.Fatal(err)
+	}
+	nwp.SetSNSSignatureVerification(false)
+	post := func(n map[string]string) int {
+		t.Helper()
+		bs, err := json.Marshal(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/amazon-sns", bytes.NewReader(bs))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		nwp.ServeHTTP(w, req)
+		return w.Code
+	}
+	confirmation := newTestSNSSubscriptionConfirmation("arn:aws:sns:us-east-1:123456789012:billing")
+	if code := post(confirmation); code != http.StatusBadRequest {
+		t.Errorf("expected bad request without the default channel, got %d", code)
+	}
+	var confirmed bool
+	nwp.confirmSNSSubscription = func(_ context.Context, n *HTTPNotification, region string) (string, error) {
+		confirmed = true
+		return n.TopicArn + ":3b9ab3ad-3d0b-4b4c-8f6d-0c6b5e6e4c3a", nil
+	}
+	nwp.SetDefaultChannel("#aws")
+	if code := post(confirmation); code != http.StatusOK || !confirmed || posted["#aws"] != 1 {
+		t.Errorf("expected to confirm and post to the default channel, got %d, confirmed %v, posted %d", code, confirmed, posted["#aws"])
+	}
+
+	// the channel message attribute is used without the routing rules.
+	if err := nwp.SetRoutingConfig(nil); err != nil {
+		t.Fatal(err)
+	}
+	n := newTestSNSNotification()
+	bs, err := json.Marshal(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs = bytes.Replace(bs, []byte(`{`), []byte(`{"MessageAttributes":{"channel":{"Type":"String","Value":"#attribute"}},`), 1)
+	req := httptest.NewRequest(http.MethodPost, "/amazon-sns", bytes.NewReader(bs))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	nwp.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || posted["#attribute"] != 1 {
+		t.Errorf("expected to post to the channel of the attribute, got %d, posted %d", w.Code, posted["#attribute"])
+	}
+}
+
+func TestPostRouted(t *testing.T) {
+	var mu sync.Mutex
+	posted := make(map[string]int)
+	nwp := newMockedNowPaste(t, io.Discard, mockChannelNotFound("", &mu, posted))
+	if err := nwp.SetRoutingConfig(loadTestRoutingConfig(t)); err != nil {
+		t.Fatal(err)
+	}
+	nwp.SetSNSSignatureVerification(false)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"deployment_status":{"state":"failure"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", "deployment_status")
+	w := httptest.NewRecorder()
+	nwp.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+
+	n := newTestSNSNotification()
+	n["TopicArn"] = "arn:aws:sns:us-east-1:123456789012:alarms-db"
+	n["Subject"] = "ALARM: db cpu"
+	bs, err := json.Marshal(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs = bytes.Replace(bs, []byte(`{`), []byte(`{"MessageAttributes":{"severity":{"Type":"String","Value":"high"}},`), 1)
+	req = httptest.NewRequest(http.MethodPost, "/amazon-sns", bytes.NewReader(bs))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	nwp.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"build":{"logs":[]}}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	nwp.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected bad request for unrouted request, got %d", w.Code)
+	}
+
+	for channel, count := range map[string]int{"#deploy": 1, "#ops": 1, "#oncall": 1} {
+		if posted[channel] != count {
+			t.Errorf("expected to post to %s %d times, posted %d times", channel, count, posted[channel])
+		}
+	}
+}
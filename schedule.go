@@ -0,0 +1,438 @@
+package nowpaste
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// DefaultSchedulerInterval is the default interval to check the scheduled jobs which are due.
+const DefaultSchedulerInterval = 10 * time.Second
+
+const scheduledBySlack = "slack"
+const scheduledByNowPaste = "nowpaste"
+
+// ScheduleTime is the time to post the content. In JSON, it is a Unix time or a RFC3339 string.
+type ScheduleTime struct {
+	time.Time
+}
+
+// ParseScheduleTime parses a Unix time or a RFC3339 string.
+func ParseScheduleTime(s string) (*ScheduleTime, error) {
+	s = strings.TrimSpace(s)
+	if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return &ScheduleTime{Time: time.Unix(unix, 0)}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, fmt.Errorf("post_at must be a Unix time or RFC3339: %w", err)
+	}
+	return &ScheduleTime{Time: t}, nil
+}
+
+func (t *ScheduleTime) UnmarshalJSON(bs []byte) error {
+	var s string
+	if err := json.Unmarshal(bs, &s); err != nil {
+		s = string(bs)
+	}
+	parsed, err := ParseScheduleTime(s)
+	if err != nil {
+		return err
+	}
+	*t = *parsed
+	return nil
+}
+
+func (t ScheduleTime) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatInt(t.Unix(), 10)), nil
+}
+
+// ScheduledJob is a content held by the scheduler of nowpaste, for contents which Slack can not schedule, such as files.
+type ScheduledJob struct {
+	ID        string    `json:"id"`
+	Content   *Content  `json:"content"`
+	PostAt    time.Time `json:"post_at"`
+	Attempts  int       `json:"attempts,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ScheduleStore interface {
+	Put(ctx context.Context, job *ScheduledJob) error
+	Get(ctx context.Context, id string) (job *ScheduledJob, ok bool, err error)
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context) ([]*ScheduledJob, error)
+}
+
+type InmemoryScheduleStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*ScheduledJob
+}
+
+var _ ScheduleStore = (*InmemoryScheduleStore)(nil)
+
+func NewInmemoryScheduleStore() *InmemoryScheduleStore {
+	return &InmemoryScheduleStore{
+		jobs: make(map[string]*ScheduledJob),
+	}
+}
+
+func (s *InmemoryScheduleStore) Put(_ context.Context, job *ScheduledJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	return nil
+}
+
+func (s *InmemoryScheduleStore) Get(_ context.Context, id string) (*ScheduledJob, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	job, ok := s.jobs[id]
+	return job, ok, nil
+}
+
+func (s *InmemoryScheduleStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+	return nil
+}
+
+func (s *InmemoryScheduleStore) List(_ context.Context) ([]*ScheduledJob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	jobs := make([]*ScheduledJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	sortScheduledJobs(jobs)
+	return jobs, nil
+}
+
+func sortScheduledJobs(jobs []*ScheduledJob) {
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].PostAt.Equal(jobs[j].PostAt) {
+			return jobs[i].PostAt.Before(jobs[j].PostAt)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+}
+
+// FileScheduleStore persists scheduled jobs as JSON files in the directory, one file per job.
+type FileScheduleStore struct {
+	store *fileDirStore[*ScheduledJob]
+}
+
+var _ ScheduleStore = (*FileScheduleStore)(nil)
+
+func NewFileScheduleStore(dir string) (*FileScheduleStore, error) {
+	store, err := newFileDirStore[*ScheduledJob](dir, "scheduled job")
+	if err != nil {
+		return nil, err
+	}
+	return &FileScheduleStore{store: store}, nil
+}
+
+func (s *FileScheduleStore) Put(_ context.Context, job *ScheduledJob) error {
+	return s.store.put(job.ID, job)
+}
+
+func (s *FileScheduleStore) Get(_ context.Context, id string) (*ScheduledJob, bool, error) {
+	return s.store.get(id)
+}
+
+func (s *FileScheduleStore) Delete(_ context.Context, id string) error {
+	return s.store.delete(id)
+}
+
+func (s *FileScheduleStore) List(_ context.Context) ([]*ScheduledJob, error) {
+	jobs, err := s.store.list()
+	if err != nil {
+		return nil, err
+	}
+	sortScheduledJobs(jobs)
+	return jobs, nil
+}
+
+// schedule posts the content at content.PostAt.
+// Messages are scheduled by chat.scheduleMessage, and the others are held by the scheduler of nowpaste.
+func (nwp *NowPaste) schedule(ctx context.Context, content *Content) (*PostResult, error) {
+	if len(content.Files) == 0 && nwp.detectPostMode(content) == postAsMessage {
+		return nwp.scheduleMessage(ctx, content)
+	}
+	return nwp.scheduleJob(ctx, content)
+}
+
+func (nwp *NowPaste) scheduleMessage(ctx context.Context, content *Content) (*PostResult, error) {
+	opts, truncated, err := postMessageOptions(content)
+	if err != nil {
+		return nil, err
+	}
+	if content.MessageKey != "" {
+		log.Printf("[warn] message key `%s` is not recorded for scheduled messages", content.MessageKey)
+	}
+	if content.ThreadKey != "" && content.ThreadTS == "" {
+		log.Printf("[warn] thread key `%s` is not stored for scheduled messages", content.ThreadKey)
+	}
+	channel := nwp.resolveChannelID(ctx, content.Channel)
+	postAt := strconv.FormatInt(content.PostAt.Unix(), 10)
+	var channelID, scheduledMessageID string
+	err, _ = nwp.retrier.Do(ctx, func() error {
+		var err error
+		channelID, scheduledMessageID, err = nwp.client.ScheduleMessageContext(ctx, channel, postAt, opts...)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("schedule message: %w", err)
+	}
+	log.Printf("[info] schedule Message `%s` to %s at %s", scheduledMessageID, channelID, content.PostAt.Format(time.RFC3339))
+	return &PostResult{
+		ChannelID:          channelID,
+		Mode:               postAsMessage,
+		Truncated:          truncated,
+		ScheduledMessageID: scheduledMessageID,
+		PostAt:             content.PostAt.Unix(),
+	}, nil
+}
+
+// scheduleJob holds the content in the schedule store, until RunScheduler posts it.
+func (nwp *NowPaste) scheduleJob(ctx context.Context, content *Content) (*PostResult, error) {
+	if nwp.schedules == nil {
+		return nil, ErrScheduleNotSupported
+	}
+	jobContent := *content
+	jobContent.PostAt = nil
+	jobContent.IdempotencyKey = ""
+	job := &ScheduledJob{
+		ID:        newID(),
+		Content:   &jobContent,
+		PostAt:    content.PostAt.Time,
+		CreatedAt: time.Now(),
+	}
+	if err := nwp.schedules.Put(ctx, job); err != nil {
+		return nil, fmt.Errorf("put scheduled job: %w", err)
+	}
+	log.Printf("[info] schedule job `%s` to %s at %s", job.ID, content.Channel, job.PostAt.Format(time.RFC3339))
+	return &PostResult{
+		ChannelID:          content.Channel,
+		Mode:               postAsFile,
+		ScheduledMessageID: job.ID,
+		PostAt:             job.PostAt.Unix(),
+	}, nil
+}
+
+// resolveChannelID returns the channel ID of the channel name if cached, otherwise the channel as is.
+func (nwp *NowPaste) resolveChannelID(ctx context.Context, channel string) string {
+	if id, ok, err := nwp.cache.Get(ctx, channel); err == nil && ok {
+		return id
+	}
+	return channel
+}
+
+// RunScheduler posts the scheduled jobs which are due, every interval until the context is canceled.
+// Jobs failed with a permanent error or too many attempts are moved to the dead letter store if configured,
+// and the others are retried at the next interval.
+func (nwp *NowPaste) RunScheduler(ctx context.Context, interval time.Duration) error {
+	if nwp.schedules == nil {
+		return errors.New("schedule store is not configured")
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		nwp.runScheduledJobs(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (nwp *NowPaste) runScheduledJobs(ctx context.Context, now time.Time) {
+	jobs, err := nwp.schedules.List(ctx)
+	if err != nil {
+		log.Printf("[error] list scheduled jobs: %s", err.Error())
+		return
+	}
+	for _, job := range jobs {
+		if job.PostAt.After(now) {
+			continue
+		}
+		content := *job.Content
+		result, err := nwp.postContent(ctx, &content)
+		if err != nil {
+			job.Attempts++
+			if !IsPermanentError(err) && job.Attempts < nwp.queueMaxAttempts {
+				log.Printf("[warn] post scheduled job `%s` (attempt %d), retry later: %s", job.ID, job.Attempts, err.Error())
+				if err := nwp.schedules.Put(ctx, job); err != nil {
+					log.Printf("[warn] put scheduled job `%s`: %s", job.ID, err.Error())
+				}
+				continue
+			}
+			log.Printf("[error] post scheduled job `%s` (attempt %d): %s", job.ID, job.Attempts, err.Error())
+			if nwp.deadLetters != nil {
+				dl := &DeadLetter{
+					Source:   "scheduler",
+					Content:  job.Content,
+					Attempts: job.Attempts,
+					Request:  &RequestMetadata{JobID: job.ID},
+				}
+				if err := nwp.putDeadLetter(ctx, dl, err); err != nil {
+					log.Printf("[error] %s", err.Error())
+					continue
+				}
+			}
+		} else {
+			log.Printf("[info] post scheduled job `%s` to %s", job.ID, result.ChannelID)
+		}
+		if err := nwp.schedules.Delete(ctx, job.ID); err != nil {
+			log.Printf("[warn] delete scheduled job `%s`: %s", job.ID, err.Error())
+		}
+	}
+}
+
+// ScheduledMessage is a message scheduled by Slack or by the scheduler of nowpaste.
+type ScheduledMessage struct {
+	ID          string `json:"id"`
+	Channel     string `json:"channel"`
+	PostAt      int64  `json:"post_at"`
+	DateCreated int64  `json:"date_created"`
+	Text        string `json:"text,omitempty"`
+	ScheduledBy string `json:"scheduled_by"`
+}
+
+func (nwp *NowPaste) listScheduledMessages(ctx context.Context, channel string) ([]ScheduledMessage, error) {
+	params := &slack.GetScheduledMessagesParameters{}
+	if channel != "" {
+		params.Channel = nwp.resolveChannelID(ctx, channel)
+	}
+	messages := make([]ScheduledMessage, 0)
+	for {
+		var scheduled []slack.ScheduledMessage
+		var cursor string
+		err, _ := nwp.retrier.Do(ctx, func() error {
+			var err error
+			scheduled, cursor, err = nwp.client.GetScheduledMessagesContext(ctx, params)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list scheduled messages: %w", err)
+		}
+		for _, m := range scheduled {
+			messages = append(messages, ScheduledMessage{
+				ID:          m.ID,
+				Channel:     m.Channel,
+				PostAt:      int64(m.PostAt),
+				DateCreated: int64(m.DateCreated),
+				Text:        m.Text,
+				ScheduledBy: scheduledBySlack,
+			})
+		}
+		if cursor == "" {
+			break
+		}
+		params.Cursor = cursor
+	}
+	if nwp.schedules != nil {
+		jobs, err := nwp.schedules.List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list scheduled jobs: %w", err)
+		}
+		for _, job := range jobs {
+			if channel != "" && job.Content.Channel != channel && job.Content.Channel != params.Channel {
+				continue
+			}
+			messages = append(messages, ScheduledMessage{
+				ID:          job.ID,
+				Channel:     job.Content.Channel,
+				PostAt:      job.PostAt.Unix(),
+				DateCreated: job.CreatedAt.Unix(),
+				Text:        job.Content.Text,
+				ScheduledBy: scheduledByNowPaste,
+			})
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].PostAt < messages[j].PostAt
+	})
+	return messages, nil
+}
+
+func (nwp *NowPaste) getScheduled(w http.ResponseWriter, req *http.Request) {
+	messages, err := nwp.listScheduledMessages(req.Context(), req.URL.Query().Get("channel"))
+	if err != nil {
+		writePostError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(messages); err != nil {
+		log.Printf("[warn] write response failed: %s", err.Error())
+	}
+}
+
+// cancelScheduled cancels the scheduled message or the scheduled job of the id.
+func (nwp *NowPaste) cancelScheduled(ctx context.Context, channel string, id string) error {
+	if nwp.schedules != nil {
+		if _, ok, err := nwp.schedules.Get(ctx, id); err != nil {
+			return fmt.Errorf("get scheduled job `%s`: %w", id, err)
+		} else if ok {
+			if err := nwp.schedules.Delete(ctx, id); err != nil {
+				return fmt.Errorf("delete scheduled job `%s`: %w", id, err)
+			}
+			log.Printf("[info] cancel scheduled job `%s`", id)
+			return nil
+		}
+	}
+	if channel == "" {
+		return ErrChannelRequired
+	}
+	channelID := nwp.resolveChannelID(ctx, channel)
+	err, _ := nwp.retrier.Do(ctx, func() error {
+		_, err := nwp.client.DeleteScheduledMessageContext(ctx, &slack.DeleteScheduledMessageParameters{
+			Channel:            channelID,
+			ScheduledMessageID: id,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("delete scheduled message `%s` in %s: %w", id, channelID, err)
+	}
+	log.Printf("[info] cancel scheduled Message `%s` in %s", id, channelID)
+	return nil
+}
+
+func (nwp *NowPaste) postScheduledDelete(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	id := req.FormValue("id")
+	if id == "" {
+		http.Error(w, "`id` is required", http.StatusBadRequest)
+		return
+	}
+	if err := nwp.cancelScheduled(req.Context(), req.FormValue("channel"), id); err != nil {
+		if errors.Is(err, ErrChannelRequired) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var ser slack.SlackErrorResponse
+		if errors.As(err, &ser) && (ser.Err == "invalid_scheduled_message_id" || ser.Err == "channel_not_found") {
+			log.Printf("[info] %s", err.Error())
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		writePostError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, http.StatusText(http.StatusOK))
+}
@@ -0,0 +1,238 @@
+package nowpaste
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func mockScheduledMessages(postAt *string) middleware {
+	return func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/chat.scheduleMessage":
+				if err := r.ParseForm(); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				*postAt = r.PostForm.Get("post_at")
+				io.WriteString(w, `{"ok":true,"channel":"C123456","scheduled_message_id":"Q1298393284","post_at":`+*postAt+`}`)
+			case "/api/chat.scheduledMessages.list":
+				io.WriteString(w, `{"ok":true,"scheduled_messages":[{"id":"Q1298393284","channel_id":"C123456","post_at":1893456000,"date_created":1700000000,"text":"good morning"}],"response_metadata":{"next_cursor":""}}`)
+			case "/api/chat.deleteScheduledMessage":
+				if err := r.ParseForm(); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				if r.PostForm.Get("scheduled_message_id") != "Q1298393284" {
+					io.WriteString(w, `{"ok":false,"error":"invalid_scheduled_message_id"}`)
+					return
+				}
+				io.WriteString(w, `{"ok":true}`)
+			default:
+				next(w, r)
+			}
+		}
+	}
+}
+
+func TestParseScheduleTime(t *testing.T) {
+	expected := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, s := range []string{"1893456000", "2030-01-01T00:00:00Z", "2030-01-01T09:00:00+09:00"} {
+		got, err := ParseScheduleTime(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(expected) {
+			t.Errorf("%s: unexpected time %s", s, got)
+		}
+	}
+	var content Content
+	if err := json.Unmarshal([]byte(`{"post_at":1893456000}`), &content); err != nil {
+		t.Fatal(err)
+	}
+	if content.PostAt == nil || !content.PostAt.Equal(expected) {
+		t.Errorf("unexpected post_at: %v", content.PostAt)
+	}
+	if _, err := ParseScheduleTime("tomorrow"); err == nil {
+		t.Error("expected error for invalid post_at")
+	}
+}
+
+func TestPostScheduledMessage(t *testing.T) {
+	var count int64
+	var postAt string
+	nwp := newMockedNowPaste(t, io.Discard, countPostMessage(&count), mockScheduledMessages(&postAt))
+	req := httptest.NewRequest(http.MethodPost, "/?channel=C0123456789&post_at=1893456000", strings.NewReader("good morning"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	nwp.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	var result PostResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.ScheduledMessageID != "Q1298393284" || result.PostAt != 1893456000 || postAt != "1893456000" {
+		t.Errorf("unexpected result: %#v, post_at=%s", result, postAt)
+	}
+	if count != 0 {
+		t.Errorf("expected not to post immediately, posted %d times", count)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/scheduled?channel=C123456", nil)
+	w = httptest.NewRecorder()
+	nwp.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	var messages []ScheduledMessage
+	if err := json.NewDecoder(w.Body).Decode(&messages); err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].ID != "Q1298393284" || messages[0].ScheduledBy != scheduledBySlack {
+		t.Errorf("unexpected scheduled messages: %#v", messages)
+	}
+
+	for id, expected := range map[string]int{"Q1298393284": http.StatusOK, "Q0000000000": http.StatusNotFound} {
+		form := url.Values{"channel": {"C123456"}, "id": {id}}
+		req = httptest.NewRequest(http.MethodPost, "/scheduled/delete", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w = httptest.NewRecorder()
+		nwp.ServeHTTP(w, req)
+		if w.Code != expected {
+			t.Errorf("delete %s: expected status %d, got %d", id, expected, w.Code)
+		}
+	}
+}
+
+func TestScheduledFileJob(t *testing.T) {
+	var uploads int64
+	nwp := newMockedNowPaste(t, io.Discard, func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/files.completeUploadExternal" {
+				atomic.AddInt64(&uploads, 1)
+			}
+			next(w, r)
+		}
+	})
+	postAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/?channel=C0123456789&as_file=true&post_at="+url.QueryEscape(postAt.Format(time.RFC3339)), strings.NewReader("report"))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Accept", "application/json")
+		return req
+	}
+	w := httptest.NewRecorder()
+	nwp.ServeHTTP(w, newRequest())
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without the schedule store, got %d", w.Code)
+	}
+
+	nwp.SetScheduleStore(NewInmemoryScheduleStore())
+	w = httptest.NewRecorder()
+	nwp.ServeHTTP(w, newRequest())
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	var result PostResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.ScheduledMessageID == "" || result.Mode != postAsFile || result.PostAt != postAt.Unix() {
+		t.Errorf("unexpected result: %#v", result)
+	}
+
+	ctx := context.Background()
+	nwp.runScheduledJobs(ctx, time.Now())
+	if uploads != 0 {
+		t.Errorf("expected not to upload before due, uploaded %d times", uploads)
+	}
+	nwp.runScheduledJobs(ctx, postAt)
+	if uploads != 1 {
+		t.Errorf("expected to upload once, uploaded %d times", uploads)
+	}
+	jobs, err := nwp.schedules.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 0 {
+		t.Errorf("expected the job to be deleted, got %d jobs", len(jobs))
+	}
+}
+
+func TestScheduledJobMaxAttempts(t *testing.T) {
+	nwp := newMockedNowPaste(t, io.Discard, failPostMessage("internal_error", 10))
+	nwp.SetScheduleStore(NewInmemoryScheduleStore())
+	nwp.SetQueueMaxAttempts(2)
+	// retries of the scheduler, not of the Slack API call.
+	nwp.SetRetryMaxAttempts(1)
+	dlq := NewInmemoryDeadLetterStore()
+	nwp.SetDeadLetterStore(dlq)
+	ctx := t.Context()
+	now := time.Now()
+	if err := nwp.schedules.Put(ctx, &ScheduledJob{ID: "job1", Content: &Content{Channel: "C0123456789", Text: "report"}, PostAt: now, CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	nwp.runScheduledJobs(ctx, now)
+	if job, ok, _ := nwp.schedules.Get(ctx, "job1"); !ok || job.Attempts != 1 {
+		t.Fatalf("expected the job to be retried, got %#v", job)
+	}
+	nwp.runScheduledJobs(ctx, now)
+	if _, ok, _ := nwp.schedules.Get(ctx, "job1"); ok {
+		t.Error("expected the job to be deleted after the max attempts")
+	}
+	if dls, _ := dlq.List(ctx); len(dls) != 1 || dls[0].Attempts != 2 || dls[0].Source != "scheduler" {
+		t.Errorf("expected a dead letter of the job, got %#v", dls)
+	}
+}
+
+func TestFileScheduleStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileScheduleStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	postAt := time.Unix(1893456000, 0)
+	for i, id := range []string{"b", "a"} {
+		job := &ScheduledJob{
+			ID:        id,
+			Content:   &Content{Channel: "C0123456789", Text: "job " + id, Files: []ContentFile{{Filename: "a.bin", Data: []byte{0xff}}}},
+			PostAt:    postAt.Add(time.Duration(i) * time.Minute),
+			CreatedAt: postAt,
+		}
+		if err := store.Put(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reopened, err := NewFileScheduleStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := reopened.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != "b" || jobs[1].Content.Text != "job a" || jobs[0].Content.Files[0].Data[0] != 0xff {
+		t.Errorf("unexpected jobs: %#v", jobs)
+	}
+	if err := reopened.Delete(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := reopened.Get(ctx, "b"); err != nil || ok {
+		t.Errorf("expected deleted, got %v, %v", ok, err)
+	}
+	if err := reopened.Put(ctx, &ScheduledJob{ID: "../escape"}); err == nil {
+		t.Error("expected error for invalid id")
+	}
+}
@@ -0,0 +1,237 @@
+package nowpaste
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SNSCertificateFetcher fetches the certificate that Amazon SNS used to sign a message.
+type SNSCertificateFetcher interface {
+	FetchCertificate(ctx context.Context, certURL string) (*x509.Certificate, error)
+}
+
+// HTTPSNSCertificateFetcher fetches signing certificates over HTTPS and caches them until they expire.
+type HTTPSNSCertificateFetcher struct {
+	client *http.Client
+	mu     sync.Mutex
+	cache  map[string]*x509.Certificate
+}
+
+var _ SNSCertificateFetcher = (*HTTPSNSCertificateFetcher)(nil)
+
+func NewHTTPSNSCertificateFetcher(client *http.Client) *HTTPSNSCertificateFetcher {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &HTTPSNSCertificateFetcher{
+		client: client,
+		cache:  make(map[string]*x509.Certificate),
+	}
+}
+
+func (f *HTTPSNSCertificateFetcher) FetchCertificate(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	f.mu.Lock()
+	cert, ok := f.cache[certURL]
+	f.mu.Unlock()
+	if ok && time.Now().Before(cert.NotAfter) {
+		return cert, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, certURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch certificate: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch certificate: unexpected status %s", resp.Status)
+	}
+	bs, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("fetch certificate: %w", err)
+	}
+	block, _ := pem.Decode(bs)
+	if block == nil {
+		return nil, errors.New("fetch certificate: pem block not found")
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+	log.Printf("[debug] fetched sns signing certificate from %s", certURL)
+	f.mu.Lock()
+	now := time.Now()
+	for u, c := range f.cache {
+		if now.After(c.NotAfter) {
+			delete(f.cache, u)
+		}
+	}
+	f.cache[certURL] = cert
+	f.mu.Unlock()
+	return cert, nil
+}
+
+var snsSigningCertHostPattern = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// ValidateSNSSigningCertURL checks that the certificate URL points to a certificate of an Amazon SNS endpoint.
+func ValidateSNSSigningCertURL(certURL string) error {
+	u, err := url.Parse(certURL)
+	if err != nil {
+		return fmt.Errorf("invalid signing cert url: %w", err)
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("signing cert url scheme must be https: %s", certURL)
+	}
+	if !snsSigningCertHostPattern.MatchString(u.Host) {
+		return fmt.Errorf("signing cert url host is not allowed: %s", u.Host)
+	}
+	// the certificates are cached by the url, so the variations of the same certificate are not allowed.
+	if u.User != nil || u.RawQuery != "" || u.ForceQuery || u.Fragment != "" {
+		return fmt.Errorf("signing cert url must not have userinfo, query or fragment: %s", certURL)
+	}
+	if !strings.HasSuffix(u.Path, ".pem") {
+		return fmt.Errorf("signing cert url is not pem: %s", certURL)
+	}
+	return nil
+}
+
+// ValidateSNSSubscribeURL validates the SubscribeURL of a subscription confirmation, which must be the ConfirmSubscription action of Amazon SNS over https.
+func ValidateSNSSubscribeURL(subscribeURL string) error {
+	u, err := url.Parse(subscribeURL)
+	if err != nil {
+		return fmt.Errorf("invalid subscribe url: %w", err)
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("subscribe url scheme must be https: %s", subscribeURL)
+	}
+	if !snsSigningCertHostPattern.MatchString(u.Hostname()) {
+		return fmt.Errorf("subscribe url host is not allowed: %s", u.Host)
+	}
+	if u.Query().Get("Action") != "ConfirmSubscription" {
+		return fmt.Errorf("subscribe url is not ConfirmSubscription: %s", subscribeURL)
+	}
+	return nil
+}
+
+// snsSignedMessage keeps the fields of HTTPNotification as they were sent, because the
+// signature covers the original string representation (e.g. Timestamp).
+type snsSignedMessage struct {
+	Type             string  `json:"Type"`
+	MessageId        string  `json:"MessageId"`
+	Token            string  `json:"Token"`
+	TopicArn         string  `json:"TopicArn"`
+	Subject          *string `json:"Subject"`
+	Message          string  `json:"Message"`
+	SubscribeURL     string  `json:"SubscribeURL"`
+	Timestamp        string  `json:"Timestamp"`
+	SignatureVersion string  `json:"SignatureVersion"`
+	Signature        string  `json:"Signature"`
+	SigningCertURL   string  `json:"SigningCertURL"`
+}
+
+// see also https://docs.aws.amazon.com/sns/latest/dg/sns-verify-signature-of-message.html
+func (m *snsSignedMessage) stringToSign() (string, error) {
+	var buf strings.Builder
+	write := func(key, value string) {
+		buf.WriteString(key)
+		buf.WriteString("\n")
+		buf.WriteString(value)
+		buf.WriteString("\n")
+	}
+	switch m.Type {
+	case "Notification":
+		write("Message", m.Message)
+		write("MessageId", m.MessageId)
+		if m.Subject != nil {
+			write("Subject", *m.Subject)
+		}
+		write("Timestamp", m.Timestamp)
+		write("TopicArn", m.TopicArn)
+		write("Type", m.Type)
+	case "SubscriptionConfirmation", "UnsubscribeConfirmation":
+		write("Message", m.Message)
+		write("MessageId", m.MessageId)
+		write("SubscribeURL", m.SubscribeURL)
+		write("Timestamp", m.Timestamp)
+		write("Token", m.Token)
+		write("TopicArn", m.TopicArn)
+		write("Type", m.Type)
+	default:
+		return "", fmt.Errorf("unknown message type `%s`", m.Type)
+	}
+	return buf.String(), nil
+}
+
+type snsVerifier struct {
+	fetcher SNSCertificateFetcher
+}
+
+func (v *snsVerifier) Verify(ctx context.Context, body []byte) error {
+	var m snsSignedMessage
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&m); err != nil {
+		return fmt.Errorf("decode message: %w", err)
+	}
+	if m.Type == "" {
+		return errors.New("not a signed sns message")
+	}
+	var hash crypto.Hash
+	switch m.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("unsupported signature version `%s`", m.SignatureVersion)
+	}
+	if err := ValidateSNSSigningCertURL(m.SigningCertURL); err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("decode signature: %w", err)
+	}
+	s, err := m.stringToSign()
+	if err != nil {
+		return err
+	}
+	cert, err := v.fetcher.FetchCertificate(ctx, m.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("signing certificate public key is not rsa")
+	}
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(s))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(s))
+		digest = sum[:]
+	}
+	if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+		return fmt.Errorf("signature mismatch: %w", err)
+	}
+	return nil
+}
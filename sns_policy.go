@@ -0,0 +1,233 @@
+package nowpaste
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+const (
+	// SNSConfirmAuto confirms the subscription automatically.
+	SNSConfirmAuto = "auto"
+	// SNSConfirmManual posts the SubscribeURL to the channel, and a human confirms it.
+	SNSConfirmManual = "manual"
+)
+
+const (
+	// SNSFailurePolicyIgnore logs the failure and answers 200 OK, so the message is lost.
+	SNSFailurePolicyIgnore = "ignore"
+	// SNSFailurePolicyRetry answers 5xx on transient failures, so Amazon SNS redelivers the message.
+	// Permanent failures are answered with 200 OK (and stored as dead letters if a store is set) to avoid infinite redelivery.
+	SNSFailurePolicyRetry = "retry"
+	// SNSFailurePolicyDeadLetter stores the message to the dead letter store and answers 200 OK.
+	SNSFailurePolicyDeadLetter = "dead-letter"
+)
+
+// SNSSubscriptionPolicy allows Amazon SNS topics to subscribe to channels.
+// All fields are glob patterns (see path.Match), and empty fields match anything.
+type SNSSubscriptionPolicy struct {
+	Channel    string   `json:"channel,omitempty"`
+	TopicArns  []string `json:"topic_arns,omitempty"`
+	AccountIDs []string `json:"account_ids,omitempty"`
+	Regions    []string `json:"regions,omitempty"`
+	Confirm    string   `json:"confirm,omitempty"`
+}
+
+// ParseSNSSubscriptionPolicies parses a JSON array of SNSSubscriptionPolicy.
+func ParseSNSSubscriptionPolicies(data []byte) ([]SNSSubscriptionPolicy, error) {
+	var policies []SNSSubscriptionPolicy
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&policies); err != nil {
+		return nil, fmt.Errorf("parse sns subscription policies: %w", err)
+	}
+	for i := range policies {
+		if err := policies[i].Validate(); err != nil {
+			return nil, fmt.Errorf("sns subscription policies[%d]: %w", i, err)
+		}
+	}
+	return policies, nil
+}
+
+func (p *SNSSubscriptionPolicy) Validate() error {
+	switch p.Confirm {
+	case "", SNSConfirmAuto, SNSConfirmManual:
+	default:
+		return fmt.Errorf("unknown confirm mode `%s`", p.Confirm)
+	}
+	patterns := []string{p.Channel}
+	patterns = append(patterns, p.TopicArns...)
+	patterns = append(patterns, p.AccountIDs...)
+	patterns = append(patterns, p.Regions...)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern `%s`: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Match reports whether the topic is allowed to subscribe to the channel by this policy.
+func (p *SNSSubscriptionPolicy) Match(channel string, topicArn arn.ARN) bool {
+	if p.Channel != "" && !matchGlob(p.Channel, channel) {
+		return false
+	}
+	return matchAnyGlob(p.TopicArns, topicArn.String()) &&
+		matchAnyGlob(p.AccountIDs, topicArn.AccountID) &&
+		matchAnyGlob(p.Regions, topicArn.Region)
+}
+
+func (p *SNSSubscriptionPolicy) ConfirmMode() string {
+	if p.Confirm == "" {
+		return SNSConfirmAuto
+	}
+	return p.Confirm
+}
+
+func matchGlob(pattern, s string) bool {
+	ok, err := path.Match(pattern, s)
+	return err == nil && ok
+}
+
+// matchAnyGlob returns true if patterns is empty or any of patterns matches s.
+func matchAnyGlob(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matchGlob(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// snsConfirmMode returns how the subscription should be confirmed, or false if it is denied.
+func (nwp *NowPaste) snsConfirmMode(channel string, topicArn arn.ARN) (string, bool) {
+	if len(nwp.snsSubscriptionPolicies) == 0 {
+		return SNSConfirmAuto, true
+	}
+	for i := range nwp.snsSubscriptionPolicies {
+		if nwp.snsSubscriptionPolicies[i].Match(channel, topicArn) {
+			return nwp.snsSubscriptionPolicies[i].ConfirmMode(), true
+		}
+	}
+	return "", false
+}
+
+func confirmSNSSubscription(ctx context.Context, n *HTTPNotification, region string) (string, error) {
+	client := sns.New(sns.Options{Region: region})
+	output, err := client.ConfirmSubscription(ctx, &sns.ConfirmSubscriptionInput{
+		Token:                     aws.String(n.Token),
+		TopicArn:                  aws.String(n.TopicArn),
+		AuthenticateOnUnsubscribe: aws.String("no"),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(output.SubscriptionArn), nil
+}
+
+// handleSNSSubscriptionConfirmation confirms the subscription according to the policies and sets the notice to content.
+// It returns the confirm mode, or false after writing an error response.
+func (nwp *NowPaste) handleSNSSubscriptionConfirmation(w http.ResponseWriter, req *http.Request, n *HTTPNotification, channel string, content *Content) (string, bool) {
+	arnObj, err := arn.Parse(n.TopicArn)
+	if err != nil {
+		log.Printf("[error] topic ARN `%s` parse failed: %s", n.TopicArn, err.Error())
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return "", false
+	}
+	mode, ok := nwp.snsConfirmMode(channel, arnObj)
+	if !ok {
+		log.Printf("[warn] sns subscription denied: topic `%s` is not allowed to subscribe to channel `%s`", n.TopicArn, channel)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return "", false
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "this message posted by nowpaste\n\n")
+	fmt.Fprintf(&out, "%s from %s\n", n.Type, n.TopicArn)
+	if subscriptionArn := req.Header.Get("x-amz-sns-subscription-arn"); subscriptionArn != "" {
+		fmt.Fprintf(&out, "Subscribe by %s\n", subscriptionArn)
+	}
+	fmt.Fprintf(&out, "Timestamp: %s\n", n.Timestamp.Format(time.RFC3339))
+	fmt.Fprintf(&out, "You have chosen to subscribe to the topic %s.\n", n.TopicArn)
+	switch mode {
+	case SNSConfirmManual:
+		// the url is posted to be clicked, so it must be of Amazon SNS even if the signature is not verified.
+		if err := ValidateSNSSubscribeURL(n.SubscribeURL); err != nil {
+			log.Printf("[warn] sns subscription from `%s` denied: %s", n.TopicArn, err.Error())
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return "", false
+		}
+		log.Printf("[info] sns subscription from `%s` to channel `%s` is waiting for manual confirmation", n.TopicArn, channel)
+		fmt.Fprintln(&out, "This Subscription is not confirmed yet. To confirm it, visit the following URL:")
+		fmt.Fprintln(&out, n.SubscribeURL)
+		content.AsMessage = true
+		nwp.putSNSSubscription(req.Context(), SNSSubscription{
+			Channel:  channel,
+			TopicArn: n.TopicArn,
+			Status:   SNSSubscriptionStatusPending,
+		})
+	default:
+		subscriptionArn, err := nwp.confirmSNSSubscription(req.Context(), n, arnObj.Region)
+		if err != nil {
+			log.Printf("[warn] sns confirm subscription failed: %s", err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return "", false
+		}
+		fmt.Fprintln(&out, "This Subscription was automatically confirmed by nowpaste.")
+		content.CodeBlockText = true
+		nwp.putSNSSubscription(req.Context(), SNSSubscription{
+			Channel:         channel,
+			TopicArn:        n.TopicArn,
+			SubscriptionArn: subscriptionArn,
+			Status:          SNSSubscriptionStatusConfirmed,
+		})
+	}
+	content.Text = out.String()
+	return mode, true
+}
+
+// handleSNSFailure answers the failure of posting the content according to the failure policy.
+func (nwp *NowPaste) handleSNSFailure(w http.ResponseWriter, req *http.Request, n *HTTPNotification, content *Content, err error) {
+	permanent := IsPermanentError(err)
+	log.Printf("[warn] %s post failed (permanent=%v): %s", n.TopicArn, permanent, err.Error())
+	switch nwp.snsFailurePolicy {
+	case SNSFailurePolicyRetry:
+		if !permanent {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if nwp.deadLetters != nil {
+			if err := nwp.putDeadLetter(req.Context(), newSNSDeadLetter(req, n, content), err); err != nil {
+				log.Printf("[error] %s", err.Error())
+			}
+		}
+	case SNSFailurePolicyDeadLetter:
+		if err := nwp.putDeadLetter(req.Context(), newSNSDeadLetter(req, n, content), err); err != nil {
+			log.Printf("[error] %s", err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, http.StatusText(http.StatusOK))
+}
+
+func newSNSDeadLetter(req *http.Request, n *HTTPNotification, content *Content) *DeadLetter {
+	return &DeadLetter{
+		Source:  "amazon-sns",
+		Content: content,
+		Request: newSNSRequestMetadata(req, n),
+	}
+}
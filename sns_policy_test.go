@@ -0,0 +1,220 @@
+package nowpaste
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestSNSSubscriptionConfirmation(topicArn string) map[string]string {
+	return map[string]string{
+		"Type":         "SubscriptionConfirmation",
+		"MessageId":    "165545c9-2a5c-472c-8df2-7ff2be2b3b1b",
+		"Token":        "2336412f37fb687f5d51e6e241d09c805a5a57b30d712f794cc5f6a988666d92768dd60a747ba6f3beb71854e285d6ad02428b09ceece29417f1f02d609c582afbacc99c583a916b9981dd2728f4ae6fdb82efd087cc3b7849e05798d2d2785c03b0879594eeac82c01f235d0e717736",
+		"TopicArn":     topicArn,
+		"Message":      "You have chosen to subscribe to the topic " + topicArn + ".\nTo confirm the subscription, visit the SubscribeURL included in this message.",
+		"SubscribeURL": "https://sns.ap-northeast-1.amazonaws.com/?Action=ConfirmSubscription&TopicArn=" + topicArn + "&Token=2336412f37",
+		"Timestamp":    "2012-04-26T20:45:04.751Z",
+	}
+}
+
+func TestParseSNSSubscriptionPolicies(t *testing.T) {
+	policies, err := ParseSNSSubscriptionPolicies([]byte(`[
+		{"channel": "C0*", "account_ids": ["123456789012"], "regions": ["ap-northeast-*"]},
+		{"topic_arns": ["arn:aws:sns:*:210987654321:alerts-*"], "confirm": "manual"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 2 {
+		t.Fatalf("unexpected policies: %#v", policies)
+	}
+	if _, err := ParseSNSSubscriptionPolicies([]byte(`[{"confirm": "always"}]`)); err == nil {
+		t.Error("expected error for unknown confirm mode")
+	}
+	if _, err := ParseSNSSubscriptionPolicies([]byte(`[{"regions": ["["]}]`)); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+func TestPostSNSSubscriptionConfirmation(t *testing.T) {
+	signer := newTestSNSSigner(t)
+	policies, err := ParseSNSSubscriptionPolicies([]byte(`[
+		{"channel": "ops", "account_ids": ["123456789012"]},
+		{"channel": "ops", "topic_arns": ["arn:aws:sns:*:210987654321:alerts-*"], "confirm": "manual"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name            string
+		channel         string
+		topicArn        string
+		subscribeURL    string
+		expectedStatus  int
+		expectedConfirm bool
+		expectedPost    string
+	}{
+		{
+			name:            "auto",
+			channel:         "ops",
+			topicArn:        "arn:aws:sns:ap-northeast-1:123456789012:MyTopic",
+			expectedStatus:  http.StatusOK,
+			expectedConfirm: true,
+			expectedPost:    "automatically+confirmed",
+		},
+		{
+			name:           "manual",
+			channel:        "ops",
+			topicArn:       "arn:aws:sns:us-east-1:210987654321:alerts-prod",
+			expectedStatus: http.StatusOK,
+			expectedPost:   "Action%3DConfirmSubscription",
+		},
+		{
+			name:           "manual_invalid_subscribe_url",
+			channel:        "ops",
+			topicArn:       "arn:aws:sns:us-east-1:210987654321:alerts-prod",
+			subscribeURL:   "https://evil.example.com/?Action=ConfirmSubscription",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "denied_topic",
+			channel:        "ops",
+			topicArn:       "arn:aws:sns:us-east-1:210987654321:billing",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "denied_channel",
+			channel:        "random",
+			topicArn:       "arn:aws:sns:ap-northeast-1:123456789012:MyTopic",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var apiCallLog bytes.Buffer
+			nwp := newMockedNowPaste(t, &apiCallLog)
+			nwp.SetSNSCertificateFetcher(signer)
+			if err := nwp.SetSNSSubscriptionPolicies(policies); err != nil {
+				t.Fatal(err)
+			}
+			var confirmed bool
+			nwp.confirmSNSSubscription = func(_ context.Context, n *HTTPNotification, region string) (string, error) {
+				confirmed = true
+				return n.TopicArn + ":3b9ab3ad-3d0b-4b4c-8f6d-0c6b5e6e4c3a", nil
+			}
+			n := newTestSNSSubscriptionConfirmation(c.topicArn)
+			if c.subscribeURL != "" {
+				n["SubscribeURL"] = c.subscribeURL
+			}
+			body := signer.Sign(t, n)
+			req := httptest.NewRequest(http.MethodPost, "/amazon-sns/"+c.channel, bytes.NewReader(body))
+			w := httptest.NewRecorder()
+			nwp.ServeHTTP(w, req)
+			if w.Code != c.expectedStatus {
+				t.Errorf("unexpected status: got %d, expected %d", w.Code, c.expectedStatus)
+			}
+			if confirmed != c.expectedConfirm {
+				t.Errorf("unexpected confirmed: got %v, expected %v", confirmed, c.expectedConfirm)
+			}
+			if c.expectedPost == "" {
+				if apiCallLog.Len() != 0 {
+					t.Errorf("unexpected api call: %s", apiCallLog.String())
+				}
+				return
+			}
+			for _, expected := range []string{c.expectedPost, "Timestamp%3A+2012-04-26T20%3A45%3A04Z"} {
+				if !strings.Contains(apiCallLog.String(), expected) {
+					t.Errorf("posted message does not contain `%s`: %s", expected, apiCallLog.String())
+				}
+			}
+		})
+	}
+}
+
+func TestPostSNSFailurePolicy(t *testing.T) {
+	signer := newTestSNSSigner(t)
+	channelNotFound := func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/chat.postMessage" {
+				w.WriteHeader(http.StatusOK)
+				io.WriteString(w, `{"ok":false,"error":"channel_not_found"}`)
+				return
+			}
+			next(w, r)
+		}
+	}
+	serverError := func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}
+	cases := []struct {
+		name               string
+		policy             string
+		middleware         middleware
+		expectedStatus     int
+		expectedDeadLetter bool
+	}{
+		{
+			name:           "ignore",
+			policy:         SNSFailurePolicyIgnore,
+			middleware:     serverError,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "retry_transient",
+			policy:         SNSFailurePolicyRetry,
+			middleware:     serverError,
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:               "retry_permanent",
+			policy:             SNSFailurePolicyRetry,
+			middleware:         channelNotFound,
+			expectedStatus:     http.StatusOK,
+			expectedDeadLetter: true,
+		},
+		{
+			name:               "dead_letter",
+			policy:             SNSFailurePolicyDeadLetter,
+			middleware:         serverError,
+			expectedStatus:     http.StatusOK,
+			expectedDeadLetter: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := NewFileDeadLetterStore(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nwp := newMockedNowPaste(t, io.Discard, c.middleware)
+			nwp.SetSNSCertificateFetcher(signer)
+			nwp.SetDeadLetterStore(store)
+			if err := nwp.SetSNSFailurePolicy(c.policy); err != nil {
+				t.Fatal(err)
+			}
+			body := signer.Sign(t, newTestSNSNotification())
+			req := httptest.NewRequest(http.MethodPost, "/amazon-sns/C0123456789", bytes.NewReader(body))
+			w := httptest.NewRecorder()
+			nwp.ServeHTTP(w, req)
+			if w.Code != c.expectedStatus {
+				t.Errorf("unexpected status: got %d, expected %d", w.Code, c.expectedStatus)
+			}
+			files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (len(files) > 0) != c.expectedDeadLetter {
+				t.Errorf("unexpected dead letters: %v", files)
+			}
+		})
+	}
+}
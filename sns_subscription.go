@@ -0,0 +1,236 @@
+package nowpaste
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SNSSubscriptionStatusConfirmed = "confirmed"
+	SNSSubscriptionStatusPending   = "pending"
+)
+
+// SNSSubscription is a subscription of an Amazon SNS topic to a channel, known by nowpaste.
+type SNSSubscription struct {
+	Channel         string    `json:"channel"`
+	TopicArn        string    `json:"topic_arn"`
+	SubscriptionArn string    `json:"subscription_arn,omitempty"`
+	Status          string    `json:"status"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+type SNSSubscriptionStore interface {
+	Get(ctx context.Context, channel string, topicArn string) (subscription SNSSubscription, ok bool, err error)
+	Put(ctx context.Context, subscription SNSSubscription) error
+	Delete(ctx context.Context, channel string, topicArn string) error
+	List(ctx context.Context) ([]SNSSubscription, error)
+}
+
+type InmemorySNSSubscriptionStore struct {
+	mu            sync.RWMutex
+	subscriptions map[string]SNSSubscription
+}
+
+var _ SNSSubscriptionStore = (*InmemorySNSSubscriptionStore)(nil)
+
+func NewInmemorySNSSubscriptionStore() *InmemorySNSSubscriptionStore {
+	return &InmemorySNSSubscriptionStore{
+		subscriptions: make(map[string]SNSSubscription),
+	}
+}
+
+func snsSubscriptionKey(channel, topicArn string) string {
+	return channel + "\x00" + topicArn
+}
+
+// sortedSNSSubscriptions returns the subscriptions sorted by the channel and the topic.
+func sortedSNSSubscriptions(m map[string]SNSSubscription) []SNSSubscription {
+	subscriptions := make([]SNSSubscription, 0, len(m))
+	for _, subscription := range m {
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		if subscriptions[i].Channel != subscriptions[j].Channel {
+			return subscriptions[i].Channel < subscriptions[j].Channel
+		}
+		return subscriptions[i].TopicArn < subscriptions[j].TopicArn
+	})
+	return subscriptions
+}
+
+func (s *InmemorySNSSubscriptionStore) Get(_ context.Context, channel string, topicArn string) (SNSSubscription, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subscription, ok := s.subscriptions[snsSubscriptionKey(channel, topicArn)]
+	return subscription, ok, nil
+}
+
+func (s *InmemorySNSSubscriptionStore) Put(_ context.Context, subscription SNSSubscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[snsSubscriptionKey(subscription.Channel, subscription.TopicArn)] = subscription
+	return nil
+}
+
+func (s *InmemorySNSSubscriptionStore) Delete(_ context.Context, channel string, topicArn string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscriptions, snsSubscriptionKey(channel, topicArn))
+	return nil
+}
+
+func (s *InmemorySNSSubscriptionStore) List(_ context.Context) ([]SNSSubscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedSNSSubscriptions(s.subscriptions), nil
+}
+
+// FileSNSSubscriptionStore persists the subscriptions to a JSON file, so that the known subscriptions survive restarts.
+type FileSNSSubscriptionStore struct {
+	mu            sync.Mutex
+	path          string
+	subscriptions map[string]SNSSubscription
+}
+
+var _ SNSSubscriptionStore = (*FileSNSSubscriptionStore)(nil)
+
+func NewFileSNSSubscriptionStore(path string) (*FileSNSSubscriptionStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create dir: %w", err)
+	}
+	s := &FileSNSSubscriptionStore{
+		path:          path,
+		subscriptions: make(map[string]SNSSubscription),
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	var subscriptions []SNSSubscription
+	if err := json.Unmarshal(bs, &subscriptions); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	for _, subscription := range subscriptions {
+		s.subscriptions[snsSubscriptionKey(subscription.Channel, subscription.TopicArn)] = subscription
+	}
+	return s, nil
+}
+
+func (s *FileSNSSubscriptionStore) Get(_ context.Context, channel string, topicArn string) (SNSSubscription, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscription, ok := s.subscriptions[snsSubscriptionKey(channel, topicArn)]
+	return subscription, ok, nil
+}
+
+func (s *FileSNSSubscriptionStore) Put(_ context.Context, subscription SNSSubscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[snsSubscriptionKey(subscription.Channel, subscription.TopicArn)] = subscription
+	return s.save()
+}
+
+func (s *FileSNSSubscriptionStore) Delete(_ context.Context, channel string, topicArn string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := snsSubscriptionKey(channel, topicArn)
+	if _, ok := s.subscriptions[key]; !ok {
+		return nil
+	}
+	delete(s.subscriptions, key)
+	return s.save()
+}
+
+func (s *FileSNSSubscriptionStore) List(_ context.Context) ([]SNSSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedSNSSubscriptions(s.subscriptions), nil
+}
+
+// save writes the subscriptions to a temporary file and renames it. Must be called with the lock held.
+func (s *FileSNSSubscriptionStore) save() error {
+	bs, err := json.Marshal(sortedSNSSubscriptions(s.subscriptions))
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", s.path, err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, bs, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("rename %s: %w", tmp, err)
+	}
+	return nil
+}
+
+// putSNSSubscription records the subscription if it is new or its state is changed,
+// so that every notification does not rewrite the store.
+func (nwp *NowPaste) putSNSSubscription(ctx context.Context, subscription SNSSubscription) {
+	known, ok, err := nwp.snsSubscriptions.Get(ctx, subscription.Channel, subscription.TopicArn)
+	if err != nil {
+		log.Printf("[warn] sns subscription store get failed: %s", err.Error())
+	}
+	if ok && known.Status == subscription.Status && known.SubscriptionArn == subscription.SubscriptionArn {
+		return
+	}
+	subscription.UpdatedAt = time.Now()
+	if err := nwp.snsSubscriptions.Put(ctx, subscription); err != nil {
+		log.Printf("[warn] sns subscription store put failed: %s", err.Error())
+	}
+}
+
+// snsLifecycleNoticeEnabled reports whether subscription lifecycle notices should be posted for the request.
+func (nwp *NowPaste) snsLifecycleNoticeEnabled(req *http.Request) bool {
+	if str := req.URL.Query().Get("lifecycle_notice"); str != "" {
+		if b, err := strconv.ParseBool(str); err == nil {
+			return b
+		}
+		log.Printf("[warn] lifecycle_notice query param parse failed: %s", str)
+	}
+	return nwp.snsLifecycleNotice
+}
+
+func (nwp *NowPaste) handleSNSUnsubscribeConfirmation(req *http.Request, n *HTTPNotification, channel string, content *Content) {
+	subscriptionArn := req.Header.Get("x-amz-sns-subscription-arn")
+	log.Printf("[info] sns unsubscribed `%s` from channel `%s`", n.TopicArn, channel)
+	if err := nwp.snsSubscriptions.Delete(req.Context(), channel, n.TopicArn); err != nil {
+		log.Printf("[warn] sns subscription store delete failed: %s", err.Error())
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "this message posted by nowpaste\n\n")
+	fmt.Fprintf(&out, "%s from %s\n", n.Type, n.TopicArn)
+	if subscriptionArn != "" {
+		fmt.Fprintf(&out, "Subscription: %s\n", subscriptionArn)
+	}
+	fmt.Fprintf(&out, "Timestamp: %s\n", n.Timestamp.Format(time.RFC3339))
+	fmt.Fprintf(&out, "This channel has been unsubscribed from the topic %s.\n", n.TopicArn)
+	content.CodeBlockText = true
+	content.Text = out.String()
+}
+
+func (nwp *NowPaste) getSNSSubscriptions(w http.ResponseWriter, req *http.Request) {
+	subscriptions, err := nwp.snsSubscriptions.List(req.Context())
+	if err != nil {
+		log.Printf("[error] sns subscription store list failed: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(subscriptions); err != nil {
+		log.Printf("[warn] write response failed: %s", err.Error())
+	}
+}
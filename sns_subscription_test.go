@@ -0,0 +1,112 @@
+package nowpaste
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSNSSubscriptionLifecycle(t *testing.T) {
+	signer := newTestSNSSigner(t)
+	topicArn := "arn:aws:sns:ap-northeast-1:123456789012:MyTopic"
+	subscriptionArn := topicArn + ":3b9ab3ad-3d0b-4b4c-8f6d-0c6b5e6e4c3a"
+	var apiCallLog bytes.Buffer
+	nwp := newMockedNowPaste(t, &apiCallLog)
+	nwp.SetSNSCertificateFetcher(signer)
+	nwp.confirmSNSSubscription = func(_ context.Context, n *HTTPNotification, region string) (string, error) {
+		return subscriptionArn, nil
+	}
+	send := func(path string, m map[string]string) int {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(signer.Sign(t, m)))
+		req.Header.Set("x-amz-sns-subscription-arn", subscriptionArn)
+		w := httptest.NewRecorder()
+		nwp.ServeHTTP(w, req)
+		return w.Code
+	}
+	list := func() []SNSSubscription {
+		req := httptest.NewRequest(http.MethodGet, "/admin/amazon-sns/subscriptions", nil)
+		w := httptest.NewRecorder()
+		nwp.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status: %d", w.Code)
+		}
+		var subscriptions []SNSSubscription
+		if err := json.NewDecoder(w.Body).Decode(&subscriptions); err != nil {
+			t.Fatal(err)
+		}
+		return subscriptions
+	}
+
+	if code := send("/amazon-sns/ops?lifecycle_notice=false", newTestSNSSubscriptionConfirmation(topicArn)); code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", code)
+	}
+	if apiCallLog.Len() != 0 {
+		t.Errorf("lifecycle notice is not suppressed: %s", apiCallLog.String())
+	}
+	subscriptions := list()
+	if len(subscriptions) != 1 {
+		t.Fatalf("unexpected subscriptions: %#v", subscriptions)
+	}
+	if subscriptions[0].Channel != "ops" || subscriptions[0].SubscriptionArn != subscriptionArn || subscriptions[0].Status != SNSSubscriptionStatusConfirmed {
+		t.Errorf("unexpected subscription: %#v", subscriptions[0])
+	}
+	// notifications of the known subscription do not rewrite the store.
+	for range 2 {
+		if code := send("/amazon-sns/ops", newTestSNSNotification()); code != http.StatusOK {
+			t.Fatalf("unexpected status: %d", code)
+		}
+	}
+	if got := list(); len(got) != 1 || !got[0].UpdatedAt.Equal(subscriptions[0].UpdatedAt) {
+		t.Errorf("expected the subscription not to be updated: %#v", got)
+	}
+
+	unsubscribe := newTestSNSSubscriptionConfirmation(topicArn)
+	unsubscribe["Type"] = "UnsubscribeConfirmation"
+	if code := send("/amazon-sns/ops", unsubscribe); code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", code)
+	}
+	if !strings.Contains(apiCallLog.String(), "UnsubscribeConfirmation+from+"+strings.ReplaceAll(topicArn, ":", "%3A")) {
+		t.Errorf("unsubscribe notice not posted: %s", apiCallLog.String())
+	}
+	if subscriptions := list(); len(subscriptions) != 0 {
+		t.Errorf("subscription is not removed: %#v", subscriptions)
+	}
+}
+
+func TestFileSNSSubscriptionStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscriptions.json")
+	store, err := NewFileSNSSubscriptionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := t.Context()
+	for _, subscription := range []SNSSubscription{
+		{Channel: "ops", TopicArn: "arn:aws:sns:ap-northeast-1:123456789012:MyTopic", Status: SNSSubscriptionStatusPending},
+		{Channel: "ops", TopicArn: "arn:aws:sns:ap-northeast-1:123456789012:MyTopic", Status: SNSSubscriptionStatusConfirmed},
+		{Channel: "dev", TopicArn: "arn:aws:sns:ap-northeast-1:123456789012:Other", Status: SNSSubscriptionStatusConfirmed},
+	} {
+		if err := store.Put(ctx, subscription); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete(ctx, "dev", "arn:aws:sns:ap-northeast-1:123456789012:Other"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFileSNSSubscriptionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscriptions, err := reopened.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscriptions) != 1 || subscriptions[0].Channel != "ops" || subscriptions[0].Status != SNSSubscriptionStatusConfirmed {
+		t.Errorf("unexpected subscriptions: %#v", subscriptions)
+	}
+}
@@ -0,0 +1,253 @@
+package nowpaste
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testSigningCertURL = "https://sns.ap-northeast-1.amazonaws.com/SimpleNotificationService-0000000000000000000000.pem"
+
+type testSNSSigner struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newTestSNSSigner(t *testing.T) *testSNSSigner {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSNSSigner{key: key, cert: cert}
+}
+
+func (s *testSNSSigner) FetchCertificate(_ context.Context, _ string) (*x509.Certificate, error) {
+	return s.cert, nil
+}
+
+// Sign fills SignatureVersion, SigningCertURL and Signature of the message, and returns the encoded body.
+func (s *testSNSSigner) Sign(t *testing.T, m map[string]string) []byte {
+	t.Helper()
+	if m["SignatureVersion"] == "" {
+		m["SignatureVersion"] = "2"
+	}
+	if m["SigningCertURL"] == "" {
+		m["SigningCertURL"] = testSigningCertURL
+	}
+	bs, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sm snsSignedMessage
+	if err := json.Unmarshal(bs, &sm); err != nil {
+		t.Fatal(err)
+	}
+	str, err := sm.stringToSign()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sig []byte
+	if m["SignatureVersion"] == "1" {
+		sum := sha1.Sum([]byte(str))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA1, sum[:])
+	} else {
+		sum := sha256.Sum256([]byte(str))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	m["Signature"] = base64.StdEncoding.EncodeToString(sig)
+	bs, err = json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bs
+}
+
+func newTestSNSNotification() map[string]string {
+	return map[string]string{
+		"Type":      "Notification",
+		"MessageId": "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+		"TopicArn":  "arn:aws:sns:ap-northeast-1:123456789012:MyTopic",
+		"Subject":   "My First Message",
+		"Message":   "Hello world!",
+		"Timestamp": "2012-05-02T00:54:06.650Z",
+	}
+}
+
+func TestPostSNSSignatureVerification(t *testing.T) {
+	signer := newTestSNSSigner(t)
+	cases := []struct {
+		name           string
+		body           func(t *testing.T) []byte
+		expectedStatus int
+	}{
+		{
+			name: "signature_version_1",
+			body: func(t *testing.T) []byte {
+				m := newTestSNSNotification()
+				m["SignatureVersion"] = "1"
+				return signer.Sign(t, m)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "signature_version_2",
+			body: func(t *testing.T) []byte {
+				return signer.Sign(t, newTestSNSNotification())
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "tampered_message",
+			body: func(t *testing.T) []byte {
+				var m map[string]string
+				json.Unmarshal(signer.Sign(t, newTestSNSNotification()), &m)
+				m["Message"] = "forged"
+				bs, _ := json.Marshal(m)
+				return bs
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "not_allowed_cert_host",
+			body: func(t *testing.T) []byte {
+				m := newTestSNSNotification()
+				m["SigningCertURL"] = "https://example.com/SimpleNotificationService.pem"
+				return signer.Sign(t, m)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "unknown_signature_version",
+			body: func(t *testing.T) []byte {
+				m := newTestSNSNotification()
+				m["SignatureVersion"] = "3"
+				return signer.Sign(t, m)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "raw_message",
+			body: func(t *testing.T) []byte {
+				return []byte("Hello world!")
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			nwp := newMockedNowPaste(t, io.Discard)
+			nwp.SetSNSCertificateFetcher(signer)
+			req := httptest.NewRequest(http.MethodPost, "/amazon-sns/test", bytes.NewReader(c.body(t)))
+			req.Header.Set("Content-Type", "text/plain; charset=UTF-8")
+			w := httptest.NewRecorder()
+			nwp.ServeHTTP(w, req)
+			if w.Code != c.expectedStatus {
+				t.Errorf("unexpected status: got %d, expected %d", w.Code, c.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestValidateSNSSubscribeURL(t *testing.T) {
+	cases := map[string]bool{
+		"https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription&TopicArn=arn:aws:sns:us-east-1:123456789012:MyTopic&Token=2336412f37": true,
+		"http://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription&Token=2336412f37":                                                      false,
+		"https://sns.us-east-1.amazonaws.com.evil.com/?Action=ConfirmSubscription&Token=2336412f37":                                            false,
+		"https://evil.com/?Action=ConfirmSubscription&Token=2336412f37":                                                                        false,
+		"https://sns.us-east-1.amazonaws.com/?Action=Unsubscribe&SubscriptionArn=arn:aws:sns:us-east-1:123456789012:MyTopic":                   false,
+	}
+	for u, ok := range cases {
+		err := ValidateSNSSubscribeURL(u)
+		if ok && err != nil {
+			t.Errorf("%s: unexpected error: %s", u, err)
+		}
+		if !ok && err == nil {
+			t.Errorf("%s: expected error", u)
+		}
+	}
+}
+
+func TestHTTPSNSCertificateFetcher(t *testing.T) {
+	signer := newTestSNSSigner(t)
+	var fetched int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: signer.cert.Raw})
+	}))
+	defer ts.Close()
+	f := NewHTTPSNSCertificateFetcher(ts.Client())
+	f.cache["expired"] = &x509.Certificate{NotAfter: time.Now().Add(-time.Hour)}
+	for range 2 {
+		cert, err := f.FetchCertificate(t.Context(), ts.URL+"/SimpleNotificationService-abc.pem")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cert.Equal(signer.cert) {
+			t.Error("unexpected certificate")
+		}
+	}
+	if fetched != 1 {
+		t.Errorf("expected the certificate to be cached, fetched %d times", fetched)
+	}
+	if _, ok := f.cache["expired"]; ok || len(f.cache) != 1 {
+		t.Errorf("expected the expired certificate to be evicted, got %d certificates", len(f.cache))
+	}
+}
+
+func TestValidateSNSSigningCertURL(t *testing.T) {
+	cases := map[string]bool{
+		"https://sns.us-east-1.amazonaws.com/SimpleNotificationService-abc.pem":      true,
+		"https://sns.cn-north-1.amazonaws.com.cn/SimpleNotificationService-abc.pem":  true,
+		"http://sns.us-east-1.amazonaws.com/SimpleNotificationService-abc.pem":       false,
+		"https://sns.us-east-1.amazonaws.com.evil.com/SimpleNotificationService.pem": false,
+		"https://evil.com/sns.us-east-1.amazonaws.com/SimpleNotificationService.pem": false,
+		"https://sns.us-east-1.amazonaws.com/SimpleNotificationService-abc.txt":      false,
+		"https://sns.us-east-1.amazonaws.com:8443/SimpleNotificationService-abc.pem": false,
+		"https://sns.us-east-1.amazonaws.com/SimpleNotificationService-abc.pem?v=1":  false,
+		"https://sns.us-east-1.amazonaws.com/SimpleNotificationService-abc.pem?":     false,
+		"https://sns.us-east-1.amazonaws.com/SimpleNotificationService-abc.pem#1":    false,
+		"https://user@sns.us-east-1.amazonaws.com/SimpleNotificationService-abc.pem": false,
+	}
+	for u, ok := range cases {
+		err := ValidateSNSSigningCertURL(u)
+		if ok && err != nil {
+			t.Errorf("%s: unexpected error: %s", u, err)
+		}
+		if !ok && err == nil {
+			t.Errorf("%s: expected error", u)
+		}
+	}
+}
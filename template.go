@@ -0,0 +1,213 @@
+package nowpaste
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// templateExt is the extension of template files loaded by LoadTemplates.
+const templateExt = ".tmpl"
+
+// ErrTemplateNotFound is returned when the template of the name is not defined.
+var ErrTemplateNotFound = errors.New("template not found")
+
+// Templates are named text/template templates to render JSON payloads into contents.
+// If the rendered output is a JSON object, it is decoded as a content (e.g. `{"blocks": [...]}`),
+// a JSON array is decoded as blocks, and otherwise the output is the text.
+type Templates struct {
+	mu        sync.RWMutex
+	templates map[string]*template.Template
+}
+
+func NewTemplates() *Templates {
+	return &Templates{
+		templates: make(map[string]*template.Template),
+	}
+}
+
+// LoadTemplates loads the `*.tmpl` files in the directory, named by the filename without the extension.
+func LoadTemplates(dir string) (*Templates, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+templateExt))
+	if err != nil {
+		return nil, fmt.Errorf("list templates: %w", err)
+	}
+	t := NewTemplates()
+	for _, name := range names {
+		bs, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("read template: %w", err)
+		}
+		if err := t.Add(strings.TrimSuffix(filepath.Base(name), templateExt), string(bs)); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Add parses the text as the template of the name, and replaces the template of the same name.
+func (t *Templates) Add(name string, text string) error {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return fmt.Errorf("parse template `%s`: %w", name, err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.templates[name] = tmpl
+	return nil
+}
+
+// Render renders the decoded JSON data by the template of the name.
+func (t *Templates) Render(name string, data interface{}) (*Content, error) {
+	t.mu.RLock()
+	tmpl, ok := t.templates[name]
+	t.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("render template `%s`: %w", name, err)
+	}
+	out := bytes.TrimSpace(buf.Bytes())
+	content := &Content{}
+	switch {
+	case len(out) > 0 && out[0] == '{':
+		if err := json.Unmarshal(out, content); err != nil {
+			return nil, fmt.Errorf("decode output of template `%s`: %w", name, err)
+		}
+	case len(out) > 0 && out[0] == '[':
+		if !json.Valid(out) {
+			return nil, fmt.Errorf("decode output of template `%s`: invalid blocks", name)
+		}
+		content.Blocks = json.RawMessage(out)
+	default:
+		content.Text = string(out)
+	}
+	return content, nil
+}
+
+var templateFuncs = template.FuncMap{
+	"date":     templateDate,
+	"truncate": templateTruncate,
+	"jsonPath": templateJSONPath,
+	"json":     templateJSON,
+	"escape":   escapeMrkdwn,
+	"default":  templateDefault,
+}
+
+// templateDate formats the time, which is a RFC3339 string or a Unix time in seconds, e.g. `{{ date "2006-01-02 15:04" .startsAt }}`.
+func templateDate(layout string, v interface{}) (string, error) {
+	var t time.Time
+	switch s := v.(type) {
+	case string:
+		parsed, err := ParseScheduleTime(s)
+		if err != nil {
+			return "", err
+		}
+		t = parsed.Time
+	case float64:
+		t = time.Unix(int64(s), 0)
+	case int:
+		t = time.Unix(int64(s), 0)
+	case int64:
+		t = time.Unix(s, 0)
+	case time.Time:
+		t = s
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("date: unsupported value %v", v)
+	}
+	return t.Format(layout), nil
+}
+
+// templateTruncate truncates the string to n characters, with the ellipsis if truncated.
+func templateTruncate(n int, v interface{}) string {
+	s := templateString(v)
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n <= 1 {
+		return string([]rune(s)[:max(n, 0)])
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+// templateJSONPath looks up the value of the dot separated path, e.g. `{{ jsonPath "alerts.0.labels.severity" . }}`.
+func templateJSONPath(path string, v interface{}) interface{} {
+	found, ok := lookupJSONPath(v, path)
+	if !ok {
+		return nil
+	}
+	return found
+}
+
+// templateJSON encodes the value in JSON, to embed strings safely in Block Kit templates.
+func templateJSON(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func templateDefault(d interface{}, v interface{}) interface{} {
+	if v == nil || v == "" {
+		return d
+	}
+	return v
+}
+
+func templateString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return jsonValueString(v)
+}
+
+// applyTemplate renders the payload of the content by the template, and replaces the body of the content.
+func (nwp *NowPaste) applyTemplate(content *Content, name string) error {
+	if nwp.templates == nil {
+		return fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	var data interface{}
+	if len(content.payload) > 0 {
+		if err := json.Unmarshal(content.payload, &data); err != nil {
+			return fmt.Errorf("decode payload: %w", err)
+		}
+	} else {
+		data = content.Text
+	}
+	rendered, err := nwp.templates.Render(name, data)
+	if err != nil {
+		return err
+	}
+	log.Printf("[debug] rendered by template `%s`", name)
+	content.Text = ""
+	content.Blocks = nil
+	content.Attachments = nil
+	content.CodeBlockText = false
+	content.isJSON = nil
+	content.Merge(rendered)
+	return nil
+}
+
+// templateName returns the name of the template for the content, by the `template` query param or the routing rule.
+func templateName(content *Content, query string) string {
+	if query != "" {
+		return query
+	}
+	return content.template
+}
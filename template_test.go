@@ -0,0 +1,150 @@
+package nowpaste
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// capturePostMessage records the form of chat.postMessage requests.
+func capturePostMessage(forms *[]map[string]string) middleware {
+	return func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/chat.postMessage" {
+				if err := r.ParseForm(); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				form := make(map[string]string)
+				for key := range r.PostForm {
+					form[key] = r.PostForm.Get(key)
+				}
+				*forms = append(*forms, form)
+			}
+			next(w, r)
+		}
+	}
+}
+
+func loadTestTemplates(t *testing.T) *Templates {
+	t.Helper()
+	templates, err := LoadTemplates("testdata/templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return templates
+}
+
+func TestTemplatesRender(t *testing.T) {
+	templates := loadTestTemplates(t)
+	var data interface{}
+	if err := json.Unmarshal([]byte(`{"service":"api<1>","version":"v1.2.3","finished_at":"2024-01-02T03:04:05Z","description":"a long description of the deployment"}`), &data); err != nil {
+		t.Fatal(err)
+	}
+	content, err := templates.Render("deploy", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ":rocket: api&lt;1&gt; v1.2.3 deployed by someone at 2024-01-02 03:04 UTC\na long description …"
+	if content.Text != expected {
+		t.Errorf("unexpected text:\n%s\nexpected:\n%s", content.Text, expected)
+	}
+	if _, err := templates.Render("unknown", data); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("expected template not found, got %v", err)
+	}
+	if err := templates.Add("broken", "{{ .a "); err == nil {
+		t.Error("expected parse error")
+	}
+}
+
+func TestPostTemplate(t *testing.T) {
+	var forms []map[string]string
+	nwp := newMockedNowPaste(t, io.Discard, capturePostMessage(&forms))
+	nwp.SetTemplates(loadTestTemplates(t))
+	config, err := ParseRoutingConfig([]byte(`
+rules:
+  - match:
+      fields:
+        receiver: "^slack$"
+    channel: C0123456789
+    template: alert_blocks
+templates:
+  inline: "{{ .message }}!"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nwp.SetRoutingConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		path   string
+		body   string
+		check  func(t *testing.T, form map[string]string)
+		status int
+	}{
+		{
+			name: "query",
+			path: "/?channel=C0123456789&template=inline",
+			body: `{"message":"hello"}`,
+			check: func(t *testing.T, form map[string]string) {
+				if form["text"] != "hello!" {
+					t.Errorf("unexpected text: %s", form["text"])
+				}
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "routing_rule",
+			path: "/",
+			body: `{"receiver":"slack","status":"firing","alerts":[{"labels":{"alertname":"HighLatency"},"annotations":{"summary":"p99 > 1s"}}]}`,
+			check: func(t *testing.T, form map[string]string) {
+				if form["text"] != "firing: HighLatency" {
+					t.Errorf("unexpected text: %s", form["text"])
+				}
+				var blocks []struct {
+					Text struct {
+						Text string `json:"text"`
+					} `json:"text"`
+				}
+				if err := json.Unmarshal([]byte(form["blocks"]), &blocks); err != nil {
+					t.Fatal(err)
+				}
+				if len(blocks) != 1 || blocks[0].Text.Text != "*HighLatency* p99 &gt; 1s" {
+					t.Errorf("unexpected blocks: %s", form["blocks"])
+				}
+			},
+			status: http.StatusOK,
+		},
+		{
+			name:   "unknown",
+			path:   "/?channel=C0123456789&template=unknown",
+			body:   `{"message":"hello"}`,
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			forms = nil
+			req := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			nwp.ServeHTTP(w, req)
+			if w.Code != c.status {
+				t.Fatalf("unexpected status: %d", w.Code)
+			}
+			if c.check == nil {
+				return
+			}
+			if len(forms) != 1 {
+				t.Fatalf("expected to post once, posted %d times", len(forms))
+			}
+			c.check(t, forms[0])
+		})
+	}
+}
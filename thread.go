@@ -0,0 +1,83 @@
+package nowpaste
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultThreadKeyTTL is the default duration to keep the parent message of a thread key.
+const DefaultThreadKeyTTL = 7 * 24 * time.Hour
+
+type ThreadEntry struct {
+	Channel   string
+	ThreadKey string
+	ThreadTS  string
+	TTL       time.Time
+}
+
+// ThreadStore stores the timestamp of the parent message for each channel and thread key.
+type ThreadStore interface {
+	Get(ctx context.Context, channel string, threadKey string) (threadTS string, ok bool, err error)
+	Set(ctx context.Context, entry ThreadEntry) error
+}
+
+type InmemoryThreadStore struct {
+	mu      sync.RWMutex
+	entries map[string]ThreadEntry
+	sweptAt time.Time
+}
+
+var _ ThreadStore = (*InmemoryThreadStore)(nil)
+
+func NewInmemoryThreadStore() *InmemoryThreadStore {
+	return &InmemoryThreadStore{
+		entries: make(map[string]ThreadEntry),
+	}
+}
+
+func threadStoreKey(channel, threadKey string) string {
+	return channel + "\x00" + threadKey
+}
+
+func (s *InmemoryThreadStore) Get(_ context.Context, channel string, threadKey string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.entries[threadStoreKey(channel, threadKey)]
+	if !ok || entry.TTL.Before(time.Now()) {
+		return "", false, nil
+	}
+	return entry.ThreadTS, true, nil
+}
+
+func (s *InmemoryThreadStore) Set(_ context.Context, entry ThreadEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweptAt = sweepExpired(s.entries, func(e ThreadEntry) time.Time { return e.TTL }, s.sweptAt, time.Now())
+	s.entries[threadStoreKey(entry.Channel, entry.ThreadKey)] = entry
+	return nil
+}
+
+// FileThreadStore persists thread keys to a JSON file, so that threads survive restarts.
+type FileThreadStore struct {
+	store *fileTTLStore[string]
+}
+
+var _ ThreadStore = (*FileThreadStore)(nil)
+
+func NewFileThreadStore(path string) (*FileThreadStore, error) {
+	store, err := newFileTTLStore[string](path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileThreadStore{store: store}, nil
+}
+
+func (s *FileThreadStore) Get(_ context.Context, channel string, threadKey string) (string, bool, error) {
+	threadTS, ok := s.store.get(threadStoreKey(channel, threadKey))
+	return threadTS, ok, nil
+}
+
+func (s *FileThreadStore) Set(_ context.Context, entry ThreadEntry) error {
+	return s.store.set(threadStoreKey(entry.Channel, entry.ThreadKey), entry.ThreadTS, entry.TTL)
+}
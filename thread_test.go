@@ -0,0 +1,112 @@
+package nowpaste
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPostThreadKey(t *testing.T) {
+	var apiCallLog bytes.Buffer
+	nwp := newMockedNowPaste(t, &apiCallLog)
+	post := func(body string) string {
+		t.Helper()
+		apiCallLog.Reset()
+		req := httptest.NewRequest(http.MethodPost, "/?channel=C0123456789&thread_key=deploy-42", strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+		nwp.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status: %d", w.Code)
+		}
+		return apiCallLog.String()
+	}
+	if calls := post("deploy started"); strings.Contains(calls, "thread_ts=") {
+		t.Errorf("first post should be the parent: %s", calls)
+	}
+	// timestamp of the mocked chat.postMessage response
+	if calls := post("deploy finished"); !strings.Contains(calls, "thread_ts=1503435956.000247") {
+		t.Errorf("second post should be replied to the thread: %s", calls)
+	}
+	threadTS, ok, err := nwp.threads.Get(context.Background(), "C0123456789", "deploy-42")
+	if err != nil || !ok || threadTS != "1503435956.000247" {
+		t.Errorf("unexpected thread entry: %s, %v, %v", threadTS, ok, err)
+	}
+}
+
+// countThreadParents counts the messages posted without thread_ts.
+func countThreadParents(count *int64) middleware {
+	return func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/chat.postMessage" {
+				if err := r.ParseForm(); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				if r.PostForm.Get("thread_ts") == "" {
+					atomic.AddInt64(count, 1)
+				}
+			}
+			next(w, r)
+		}
+	}
+}
+
+func TestPostThreadKeyConcurrently(t *testing.T) {
+	var parents int64
+	nwp := newMockedNowPaste(t, io.Discard, countThreadParents(&parents))
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/?channel=C0123456789&thread_key=deploy-42", strings.NewReader("deploy log"))
+			req.Header.Set("Content-Type", "text/plain")
+			w := httptest.NewRecorder()
+			nwp.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Errorf("unexpected status: %d", w.Code)
+			}
+		}()
+	}
+	wg.Wait()
+	if parents != 1 {
+		t.Errorf("expected only one parent for the thread key, posted %d parents", parents)
+	}
+}
+
+func TestFileThreadStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "threads.json")
+	store, err := NewFileThreadStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := store.Set(ctx, ThreadEntry{Channel: "C0123456789", ThreadKey: "alarm", ThreadTS: "1.0", TTL: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, ThreadEntry{Channel: "C0123456789", ThreadKey: "expired", ThreadTS: "2.0", TTL: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewFileThreadStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts, ok, _ := reopened.Get(ctx, "C0123456789", "alarm"); !ok || ts != "1.0" {
+		t.Errorf("expected the thread to be persisted, got %s %v", ts, ok)
+	}
+	if _, ok, _ := reopened.Get(ctx, "C0123456789", "expired"); ok {
+		t.Error("expected the expired thread not to be found")
+	}
+	if _, ok, _ := reopened.Get(ctx, "C9999999999", "alarm"); ok {
+		t.Error("expected threads to be separated by channel")
+	}
+}
@@ -0,0 +1,184 @@
+package nowpaste
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// ttlSweepInterval is the interval to sweep the expired entries of the in-memory stores.
+	ttlSweepInterval = time.Minute
+	// ttlStoreMinCompaction is the min number of the records of a file TTL store to compact it.
+	ttlStoreMinCompaction = 1024
+)
+
+type ttlEntry[V any] struct {
+	Value V         `json:"value"`
+	TTL   time.Time `json:"ttl"`
+}
+
+// ttlRecord is a line of the file TTL store. A deleted key is recorded without the value.
+type ttlRecord[V any] struct {
+	Key     string    `json:"key"`
+	Value   V         `json:"value,omitempty"`
+	TTL     time.Time `json:"ttl"`
+	Deleted bool      `json:"deleted,omitempty"`
+}
+
+// fileTTLStore is a key value store with TTLs, persisted to a JSON lines file.
+// Each write is appended to the file, and the file is compacted to the live entries when the records grow twice as many as them.
+type fileTTLStore[V any] struct {
+	mu        sync.Mutex
+	path      string
+	entries   map[string]ttlEntry[V]
+	records   int
+	compactAt int
+}
+
+func newFileTTLStore[V any](path string) (*fileTTLStore[V], error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create dir: %w", err)
+	}
+	s := &fileTTLStore[V]{
+		path:      path,
+		entries:   make(map[string]ttlEntry[V]),
+		compactAt: ttlStoreMinCompaction,
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(bs))
+	scanner.Buffer(nil, len(bs)+1)
+	var broken bool
+	for scanner.Scan() {
+		var r ttlRecord[V]
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// the last line may be partially written on a crash.
+			log.Printf("[warn] skip a broken record of %s: %s", path, err.Error())
+			broken = true
+			continue
+		}
+		s.records++
+		if r.Deleted {
+			delete(s.entries, r.Key)
+			continue
+		}
+		s.entries[r.Key] = ttlEntry[V]{Value: r.Value, TTL: r.TTL}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if broken {
+		// the records are not appended after the broken line.
+		if err := s.compact(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *fileTTLStore[V]) get(key string) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok || entry.TTL.Before(time.Now()) {
+		var zero V
+		return zero, false
+	}
+	return entry.Value, true
+}
+
+func (s *fileTTLStore[V]) set(key string, value V, ttl time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = ttlEntry[V]{Value: value, TTL: ttl}
+	return s.append(ttlRecord[V]{Key: key, Value: value, TTL: ttl})
+}
+
+func (s *fileTTLStore[V]) delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[key]; !ok {
+		return nil
+	}
+	delete(s.entries, key)
+	return s.append(ttlRecord[V]{Key: key, Deleted: true})
+}
+
+// append appends the record to the file, and compacts the file if the records grow. Must be called with the lock held.
+func (s *fileTTLStore[V]) append(r ttlRecord[V]) error {
+	bs, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", s.path, err)
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", s.path, err)
+	}
+	if _, err := f.Write(append(bs, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", s.path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", s.path, err)
+	}
+	s.records++
+	if s.records < s.compactAt {
+		return nil
+	}
+	return s.compact()
+}
+
+// compact rewrites the file with the live entries to a temporary file and renames it, expired entries are dropped.
+// Must be called with the lock held.
+func (s *fileTTLStore[V]) compact() error {
+	now := time.Now()
+	var buf bytes.Buffer
+	for key, entry := range s.entries {
+		if entry.TTL.Before(now) {
+			delete(s.entries, key)
+			continue
+		}
+		bs, err := json.Marshal(ttlRecord[V]{Key: key, Value: entry.Value, TTL: entry.TTL})
+		if err != nil {
+			return fmt.Errorf("encode %s: %w", s.path, err)
+		}
+		buf.Write(bs)
+		buf.WriteByte('\n')
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("rename %s: %w", tmp, err)
+	}
+	s.records = len(s.entries)
+	s.compactAt = max(2*s.records, ttlStoreMinCompaction)
+	return nil
+}
+
+// sweepExpired deletes the expired entries at most once per ttlSweepInterval, and returns the time of the last sweep.
+func sweepExpired[E any](entries map[string]E, ttl func(E) time.Time, sweptAt time.Time, now time.Time) time.Time {
+	if now.Sub(sweptAt) < ttlSweepInterval {
+		return sweptAt
+	}
+	for key, e := range entries {
+		if ttl(e).Before(now) {
+			delete(entries, key)
+		}
+	}
+	return now
+}
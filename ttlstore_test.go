@@ -0,0 +1,66 @@
+package nowpaste
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileTTLStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	store, err := newFileTTLStore[string](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttl := time.Now().Add(time.Hour)
+	for i := 0; i < ttlStoreMinCompaction+10; i++ {
+		if err := store.set("counter", "v", ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.set("expired", "v", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.set("deleted", "v", ttl); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.delete("deleted"); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(bs, []byte("\n")); lines > 20 {
+		t.Errorf("expected the file to be compacted, got %d lines", lines)
+	}
+	// a partially written record on a crash is skipped.
+	if err := os.WriteFile(path, append(bs, `{"key":"broken","val`...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := newFileTTLStore[string](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := reopened.get("counter"); !ok || v != "v" {
+		t.Errorf("expected the entry to be persisted, got %s %v", v, ok)
+	}
+	for _, key := range []string{"expired", "deleted", "broken"} {
+		if _, ok := reopened.get(key); ok {
+			t.Errorf("expected %s not to be found", key)
+		}
+	}
+	if err := reopened.set("appended", "v", ttl); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err = newFileTTLStore[string](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reopened.get("appended"); !ok {
+		t.Error("expected the entry appended after the broken record to be persisted")
+	}
+}
@@ -0,0 +1,74 @@
+package nowpaste
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// postWebhookContent posts the content rendered from a webhook payload to the channel in the path.
+// Query params (username, icon_emoji, icon_url, summary, as_file, as_message) are applied to the content,
+// and if the content is posted as a file, the raw payload is uploaded instead of the rendered message.
+func (nwp *NowPaste) postWebhookContent(w http.ResponseWriter, req *http.Request, content *Content, name string, payload []byte) {
+	channel, ok := mux.Vars(req)["channel"]
+	if !ok || channel == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	query := req.URL.Query()
+	base := nwp.newContent(req)
+	base.Merge(&Content{
+		Channel:   channel,
+		Username:  query.Get("username"),
+		IconEmoji: query.Get("icon_emoji"),
+		IconURL:   query.Get("icon_url"),
+		Summary:   query.Get("summary"),
+	})
+	base.Merge(content)
+	content = base
+	if content.Username == "" {
+		content.Username = defaultUsername
+	}
+	if content.AsFile {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, payload, "", "  "); err != nil {
+			buf.Reset()
+			buf.Write(payload)
+		}
+		content.AsMessage = false
+		content.Blocks = nil
+		content.Attachments = nil
+		content.Text = buf.String()
+		if content.Filename == "" {
+			content.Filename = name + ".json"
+		}
+	}
+	result, err := nwp.postContent(req.Context(), content)
+	if err != nil {
+		writePostError(w, err)
+		return
+	}
+	nwp.writePostResult(w, req, result)
+}
+
+// readWebhookPayload reads the request body and decodes it as JSON into v.
+// It returns the raw payload, or false after writing an error response.
+func readWebhookPayload(w http.ResponseWriter, req *http.Request, v interface{}) ([]byte, bool) {
+	defer req.Body.Close()
+	payload, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.Printf("[info] can not read body: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		log.Printf("[info] can not read as json: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return nil, false
+	}
+	return payload, true
+}